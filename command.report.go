@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/remeh/sizedwaitgroup"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	ReportFormatJson = "json"
+	ReportFormatCsv  = "csv"
+	ReportFormatHtml = "html"
+
+	ReportStateOk       = "ok"
+	ReportStateExpiring = "expiring"
+	ReportStateExpired  = "expired"
+	ReportStateNoExpiry = "noExpiry"
+	ReportStateDisabled = "disabled"
+
+	ReportExitCodeOk           = 0
+	ReportExitCodeError        = 1
+	ReportExitCodeNotCompliant = 2
+)
+
+var (
+	// content types of report with their metric lists and labels (see content collector)
+	reportContentTypes = []struct {
+		itemType     string
+		tagType      string
+		infoMetric   string
+		statusMetric string
+		nameLabel    string
+		idLabel      string
+	}{
+		{"key", "keys", "keyvaultKeyInfo", "keyvaultKeyStatus", "keyName", "keyID"},
+		{"secret", "secrets", "keyvaultSecretInfo", "keyvaultSecretStatus", "secretName", "secretID"},
+		{"certificate", "certificates", "keyvaultCertificateInfo", "keyvaultCertificateStatus", "certificateName", "certificateID"},
+	}
+
+	reportHtmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+		"time": func(val *time.Time) string {
+			if val == nil {
+				return ""
+			}
+			return val.UTC().Format(time.RFC3339)
+		},
+		"days": func(val *float64) string {
+			if val == nil {
+				return ""
+			}
+			return strconv.FormatFloat(*val, 'f', 1, 64)
+		},
+	}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Azure KeyVault expiry report</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.expired, .error { background: #f8d7da; }
+.expiring { background: #fff3cd; }
+.noExpiry { background: #e2e3e5; }
+</style>
+</head>
+<body>
+<h1>Azure KeyVault expiry report</h1>
+<p>Generated at {{ time .GeneratedAt }}, threshold {{ .Threshold }}, compliant: {{ .Compliant }}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>State</th><th>Items</th></tr>
+{{- range $state, $count := .Summary }}
+<tr class="{{ $state }}"><td>{{ $state }}</td><td>{{ $count }}</td></tr>
+{{- end }}
+</table>
+
+<h2>KeyVaults</h2>
+<table>
+<tr><th>Target</th><th>KeyVault</th><th>Resource ID</th><th>Items</th><th>Errors</th></tr>
+{{- range .Vaults }}
+<tr{{ if .Errors }} class="error"{{ end }}><td>{{ .Target }}</td><td>{{ .Name }}</td><td>{{ .ResourceID }}</td><td>{{ .Items }}</td><td>{{ range .Errors }}{{ . }}<br>{{ end }}</td></tr>
+{{- end }}
+</table>
+
+<h2>Items</h2>
+<table>
+<tr><th>KeyVault</th><th>Type</th><th>Name</th><th>State</th><th>Enabled</th><th>Expires</th><th>Expires in (days)</th><th>Created</th><th>Age (days)</th><th>Tags</th></tr>
+{{- range .Items }}
+<tr class="{{ .State }}"><td>{{ .Vault }}</td><td>{{ .Type }}</td><td>{{ .Name }}</td><td>{{ .State }}</td><td>{{ .Enabled }}</td><td>{{ time .Expires }}</td><td>{{ days .ExpiresInDays }}</td><td>{{ time .Created }}</td><td>{{ days .AgeDays }}</td><td>{{ range $name, $value := .Tags }}{{ $name }}={{ $value }}<br>{{ end }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+)
+
+type (
+	// Report is the expiry report of all KeyVault items
+	Report struct {
+		GeneratedAt *time.Time     `json:"generatedAt"`
+		Threshold   string         `json:"threshold"`
+		Compliant   bool           `json:"compliant"`
+		Summary     map[string]int `json:"summary"`
+		Vaults      []*ReportVault `json:"vaults"`
+		Items       []*ReportItem  `json:"items"`
+
+		lock sync.Mutex
+	}
+
+	ReportVault struct {
+		Target     string   `json:"target"`
+		Name       string   `json:"name"`
+		ResourceID string   `json:"resourceID"`
+		Items      int      `json:"items"`
+		Errors     []string `json:"errors,omitempty"`
+	}
+
+	ReportItem struct {
+		Target        string            `json:"target"`
+		Vault         string            `json:"vault"`
+		ResourceID    string            `json:"resourceID"`
+		Type          string            `json:"type"`
+		Name          string            `json:"name"`
+		ID            string            `json:"id"`
+		Enabled       bool              `json:"enabled"`
+		Expires       *time.Time        `json:"expires,omitempty"`
+		NotBefore     *time.Time        `json:"notBefore,omitempty"`
+		Created       *time.Time        `json:"created,omitempty"`
+		Updated       *time.Time        `json:"updated,omitempty"`
+		ExpiresInDays *float64          `json:"expiresInDays,omitempty"`
+		AgeDays       *float64          `json:"ageDays,omitempty"`
+		Tags          map[string]string `json:"tags"`
+		State         string            `json:"state"`
+	}
+)
+
+// runReport collects all KeyVaults once (same discovery, filter and content logic as the collectors) and writes the report,
+// returns exit code
+func runReport() int {
+	ctx, span := tracer.Start(context.Background(), "report")
+	defer span.End()
+
+	now := time.Now()
+	report := &Report{
+		GeneratedAt: &now,
+		Threshold:   Opts.Report.Threshold.String(),
+		Summary:     map[string]int{},
+		Vaults:      []*ReportVault{},
+		Items:       []*ReportItem{},
+	}
+
+	failed := false
+	wg := sizedwaitgroup.New(Opts.Scrape.Concurrency)
+	for _, target := range AzureTargets {
+		contextLogger := logger.With(zap.String("target", target.Name))
+
+		// all KeyVaults of target, report is not sharded
+		vaultList, err := discoverKeyVaults(ctx, target, contextLogger)
+		if err != nil {
+			contextLogger.Error(err)
+			failed = true
+		}
+
+		for _, vault := range vaultList {
+			wg.Add()
+			go func(target *AzureTarget, vault *KeyVault) {
+				defer wg.Done()
+				report.collectKeyVault(ctx, target, vault, contextLogger.With(zap.String("keyvault", vault.Name)))
+			}(target, vault)
+		}
+	}
+	wg.Wait()
+
+	report.finish(now)
+
+	for _, vault := range report.Vaults {
+		if len(vault.Errors) > 0 {
+			failed = true
+		}
+	}
+
+	if err := report.write(); err != nil {
+		logger.Errorf(`unable to write report: %v`, err)
+		return ReportExitCodeError
+	}
+
+	logger.With(zap.Any("summary", report.Summary)).Infof(`report of %d KeyVaults and %d items written`, len(report.Vaults), len(report.Items))
+
+	if !report.Compliant {
+		logger.Warnf(`found items which are expired or expire within %s`, Opts.Report.Threshold)
+		return ReportExitCodeNotCompliant
+	}
+
+	if failed {
+		logger.Warn(`report is incomplete, collection of KeyVaults failed`)
+		return ReportExitCodeError
+	}
+
+	return ReportExitCodeOk
+}
+
+// collectKeyVault collects content of KeyVault using local metric lists (as probe) and adds the items to the report
+func (r *Report) collectKeyVault(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) {
+	base := MetricsCollectorKeyvaultBase{
+		metricLists: map[string]*prometheusCommon.MetricList{},
+	}
+
+	content := &MetricsCollectorKeyvault{MetricsCollectorKeyvaultBase: base}
+	if err := content.initContentTagManager(); err != nil {
+		logger.Fatal(err)
+	}
+	content.initMetrics(func(name string, vec *prometheus.GaugeVec) {
+		base.metricLists[name] = prometheusCommon.NewMetricsList()
+	})
+
+	if Opts.Scrape.VaultTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Opts.Scrape.VaultTimeout)
+		defer cancel()
+	}
+
+	logger.Info("collecting keyvault for report")
+	content.collectKeyVault(ctx, target, vault, logger)
+
+	reportVault := &ReportVault{
+		Target:     target.Name,
+		Name:       vault.Name,
+		ResourceID: vault.ResourceID,
+	}
+
+	// access errors of scopes
+	for _, row := range base.metricLists["keyvaultStatus"].GetList() {
+		if row.Value == 0 {
+			reason := row.Labels["reason"]
+			if statusCode := row.Labels["statusCode"]; statusCode != "" {
+				reason = fmt.Sprintf(`%s (%s)`, reason, statusCode)
+			}
+			reportVault.Errors = append(reportVault.Errors, fmt.Sprintf(`%s: %s`, row.Labels["scope"], reason))
+		}
+	}
+	sort.Strings(reportVault.Errors)
+
+	// wildcard content tags by item ID
+	contentTags := map[string]map[string]string{}
+	for _, row := range base.metricLists["keyvaultContentTag"].GetList() {
+		itemID := row.Labels["type"] + ":" + row.Labels["itemID"]
+		if _, exists := contentTags[itemID]; !exists {
+			contentTags[itemID] = map[string]string{}
+		}
+		contentTags[itemID][row.Labels["tag"]] = row.Labels["value"]
+	}
+
+	items := []*ReportItem{}
+	for _, contentType := range reportContentTypes {
+		itemMap := map[string]*ReportItem{}
+
+		for _, row := range base.metricLists[contentType.infoMetric].GetList() {
+			item := &ReportItem{
+				Target:     target.Name,
+				Vault:      vault.Name,
+				ResourceID: vault.ResourceID,
+				Type:       contentType.itemType,
+				Name:       row.Labels[contentType.nameLabel],
+				ID:         row.Labels[contentType.idLabel],
+				Enabled:    row.Labels["enabled"] == "true",
+				Tags:       map[string]string{},
+			}
+
+			// configured content tags (--keyvault.content.tag)
+			for name, value := range row.Labels {
+				if strings.HasPrefix(name, ContentTagLabelPrefix) && value != "" {
+					item.Tags[strings.TrimPrefix(name, ContentTagLabelPrefix)] = value
+				}
+			}
+			for name, value := range contentTags[contentType.tagType+":"+item.ID] {
+				item.Tags[name] = value
+			}
+
+			itemMap[item.ID] = item
+			items = append(items, item)
+		}
+
+		for _, row := range base.metricLists[contentType.statusMetric].GetList() {
+			item, exists := itemMap[row.Labels[contentType.idLabel]]
+			if !exists || row.Value <= 0 {
+				continue
+			}
+
+			timestamp := time.Unix(int64(row.Value), 0)
+			switch row.Labels["type"] {
+			case "expiry":
+				item.Expires = &timestamp
+			case "notBefore":
+				item.NotBefore = &timestamp
+			case "created":
+				item.Created = &timestamp
+			case "updated":
+				item.Updated = &timestamp
+			}
+		}
+	}
+	reportVault.Items = len(items)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Vaults = append(r.Vaults, reportVault)
+	r.Items = append(r.Items, items...)
+}
+
+// finish calculates age, expiry and compliance state of all items and sorts the report
+func (r *Report) finish(now time.Time) {
+	r.Compliant = true
+
+	for _, item := range r.Items {
+		if item.Created != nil {
+			age := now.Sub(*item.Created).Hours() / 24
+			item.AgeDays = &age
+		}
+
+		switch {
+		case !item.Enabled:
+			item.State = ReportStateDisabled
+		case item.Expires == nil:
+			item.State = ReportStateNoExpiry
+		case item.Expires.Before(now):
+			item.State = ReportStateExpired
+		case item.Expires.Before(now.Add(Opts.Report.Threshold)):
+			item.State = ReportStateExpiring
+		default:
+			item.State = ReportStateOk
+		}
+
+		if item.Expires != nil {
+			expiresIn := item.Expires.Sub(now).Hours() / 24
+			item.ExpiresInDays = &expiresIn
+		}
+
+		if item.State == ReportStateExpired || item.State == ReportStateExpiring {
+			r.Compliant = false
+		}
+		r.Summary[item.State]++
+	}
+
+	sort.Slice(r.Vaults, func(i, j int) bool {
+		if r.Vaults[i].Target != r.Vaults[j].Target {
+			return r.Vaults[i].Target < r.Vaults[j].Target
+		}
+		return r.Vaults[i].Name < r.Vaults[j].Name
+	})
+
+	sort.Slice(r.Items, func(i, j int) bool {
+		a, b := r.Items[i], r.Items[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Vault != b.Vault {
+			return a.Vault < b.Vault
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+}
+
+// write writes report in selected format to output file or stdout
+func (r *Report) write() (err error) {
+	var writer io.Writer = os.Stdout
+	if Opts.Report.Output != "" && Opts.Report.Output != "-" {
+		file, err := os.Create(filepath.Clean(Opts.Report.Output))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		writer = file
+	}
+
+	switch Opts.Report.Format {
+	case ReportFormatJson:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case ReportFormatCsv:
+		return r.writeCsv(writer)
+	case ReportFormatHtml:
+		return reportHtmlTemplate.Execute(writer, r)
+	}
+
+	return fmt.Errorf(`report format "%s" not supported`, Opts.Report.Format)
+}
+
+// writeCsv writes items as CSV (one row per item, tags as name=value list)
+func (r *Report) writeCsv(writer io.Writer) error {
+	formatTime := func(val *time.Time) string {
+		if val == nil {
+			return ""
+		}
+		return val.UTC().Format(time.RFC3339)
+	}
+	formatDays := func(val *float64) string {
+		if val == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*val, 'f', 1, 64)
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write([]string{"target", "vault", "resourceID", "type", "name", "id", "enabled", "state", "expires", "expiresInDays", "notBefore", "created", "updated", "ageDays", "tags"}); err != nil {
+		return err
+	}
+
+	for _, item := range r.Items {
+		tags := []string{}
+		for name, value := range item.Tags {
+			tags = append(tags, name+"="+value)
+		}
+		sort.Strings(tags)
+
+		err := csvWriter.Write([]string{
+			item.Target,
+			item.Vault,
+			item.ResourceID,
+			item.Type,
+			item.Name,
+			item.ID,
+			strconv.FormatBool(item.Enabled),
+			item.State,
+			formatTime(item.Expires),
+			formatDays(item.ExpiresInDays),
+			formatTime(item.NotBefore),
+			formatTime(item.Created),
+			formatTime(item.Updated),
+			formatDays(item.AgeDays),
+			strings.Join(tags, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// same default as go-common service discovery cache (AZURE_SERVICEDISCOVERY_CACHE_TTL)
+	armCacheTtlDefault = 60 * time.Minute
+
+	// page size of ResourceGraph queries
+	armResourceGraphPageSize = 1000
+)
+
+type (
+	// azureArmCache caches subscriptions and resourcegroups of a target (used for discovery and resource tags)
+	azureArmCache struct {
+		ttl time.Duration
+
+		subscriptions *azureArmCacheEntry[map[string]*armsubscriptions.Subscription]
+
+		// key is lowercased subscription ID
+		resourceGroups map[string]*azureArmCacheEntry[map[string]*armresources.ResourceGroup]
+
+		lock sync.Mutex
+	}
+
+	azureArmCacheEntry[T any] struct {
+		value T
+		time  time.Time
+	}
+)
+
+func newAzureArmCache(ttl time.Duration) *azureArmCache {
+	return &azureArmCache{
+		ttl:            ttl,
+		resourceGroups: map[string]*azureArmCacheEntry[map[string]*armresources.ResourceGroup]{},
+	}
+}
+
+// valid returns true if cache entry exists and is not expired
+func (c *azureArmCache) valid(t time.Time) bool {
+	return !t.IsZero() && time.Since(t) < c.ttl
+}
+
+// listCachedSubscriptions returns all enabled subscriptions of the target credential (cached, key is subscription ID)
+func (t *AzureTarget) listCachedSubscriptions(ctx context.Context) (map[string]*armsubscriptions.Subscription, error) {
+	t.armCache.lock.Lock()
+	defer t.armCache.lock.Unlock()
+
+	if entry := t.armCache.subscriptions; entry != nil && t.armCache.valid(entry.time) {
+		return entry.value, nil
+	}
+
+	logger.With(zap.String("target", t.Name)).Debug("updating cached Azure Subscription list")
+	ctx, span := tracer.Start(ctx, "listSubscriptions", trace.WithAttributes(attribute.String("target", t.Name)))
+	list, err := t.listSubscriptions(ctx)
+	tracingEnd(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	t.armCache.subscriptions = &azureArmCacheEntry[map[string]*armsubscriptions.Subscription]{value: list, time: time.Now()}
+	return list, nil
+}
+
+// listSubscriptions returns all enabled subscriptions of the target credential (key is subscription ID)
+func (t *AzureTarget) listSubscriptions(ctx context.Context) (map[string]*armsubscriptions.Subscription, error) {
+	list := map[string]*armsubscriptions.Subscription{}
+
+	client, err := armsubscriptions.NewClient(t.GetCred(), t.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subscription := range result.Value {
+			if subscription.SubscriptionID == nil {
+				continue
+			}
+
+			// skip disabled (and deleted) subscriptions
+			if subscription.State != nil && *subscription.State == armsubscriptions.SubscriptionStateDisabled {
+				continue
+			}
+
+			list[*subscription.SubscriptionID] = subscription
+		}
+	}
+
+	return list, nil
+}
+
+// listCachedResourceGroups returns all resourcegroups of subscription (cached, key is lowercased name)
+func (t *AzureTarget) listCachedResourceGroups(ctx context.Context, subscriptionID string) (map[string]*armresources.ResourceGroup, error) {
+	t.armCache.lock.Lock()
+	defer t.armCache.lock.Unlock()
+
+	cacheKey := strings.ToLower(subscriptionID)
+	if entry, exists := t.armCache.resourceGroups[cacheKey]; exists && t.armCache.valid(entry.time) {
+		return entry.value, nil
+	}
+
+	logger.With(zap.String("target", t.Name), zap.String("subscriptionID", subscriptionID)).Debug("updating cached Azure ResourceGroup list")
+	ctx, span := tracer.Start(ctx, "listResourceGroups", trace.WithAttributes(
+		attribute.String("target", t.Name),
+		attribute.String("subscriptionID", subscriptionID),
+	))
+	list, err := t.listResourceGroups(ctx, subscriptionID)
+	tracingEnd(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	t.armCache.resourceGroups[cacheKey] = &azureArmCacheEntry[map[string]*armresources.ResourceGroup]{value: list, time: time.Now()}
+	return list, nil
+}
+
+// listResourceGroups returns all resourcegroups of subscription (key is lowercased name)
+func (t *AzureTarget) listResourceGroups(ctx context.Context, subscriptionID string) (map[string]*armresources.ResourceGroup, error) {
+	list := map[string]*armresources.ResourceGroup{}
+
+	client, err := armresources.NewResourceGroupsClient(subscriptionID, t.GetCred(), t.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resourceGroup := range result.Value {
+			list[to.StringLower(resourceGroup.Name)] = resourceGroup
+		}
+	}
+
+	return list, nil
+}
+
+// GetResourceContainerTags returns tags of resourcegroup or subscription of KeyVault (cached)
+func (t *AzureTarget) GetResourceContainerTags(ctx context.Context, vault *KeyVault, source string) (map[string]*string, error) {
+	switch source {
+	case armclient.AzureTagSourceResourceGroup:
+		list, err := t.listCachedResourceGroups(ctx, vault.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+
+		resourceGroup, exists := list[strings.ToLower(vault.ResourceGroup)]
+		if !exists {
+			return nil, fmt.Errorf(`resourceGroup "%v" not found`, vault.ResourceGroup)
+		}
+		return resourceGroup.Tags, nil
+	case armclient.AzureTagSourceSubscription:
+		list, err := t.listCachedSubscriptions(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for subscriptionID, subscription := range list {
+			if strings.EqualFold(subscriptionID, vault.SubscriptionID) {
+				return subscription.Tags, nil
+			}
+		}
+		return nil, fmt.Errorf(`subscription "%v" not found`, vault.SubscriptionID)
+	default:
+		return nil, fmt.Errorf(`resourceTag source "%v" is not supported`, source)
+	}
+}
+
+// ExecuteResourceGraphQuery executes ResourceGraph query and returns all rows (all pages)
+func (t *AzureTarget) ExecuteResourceGraphQuery(ctx context.Context, query string, options armclient.ResourceGraphOptions) (list []map[string]interface{}, err error) {
+	ctx, span := tracer.Start(ctx, "resourceGraphQuery", trace.WithAttributes(
+		attribute.String("target", t.Name),
+		attribute.Int("subscriptions", len(options.Subscriptions)),
+		attribute.StringSlice("managementGroups", options.ManagementGroups),
+	))
+	defer func() {
+		tracingEnd(span, err)
+	}()
+
+	list = []map[string]interface{}{}
+
+	client, err := armresourcegraph.NewClient(t.GetCred(), t.NewArmClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	resultFormat := armresourcegraph.ResultFormatObjectArray
+	request := armresourcegraph.QueryRequest{
+		Query: to.StringPtr(query),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: &resultFormat,
+			Top:          to.Int32Ptr(armResourceGraphPageSize),
+		},
+	}
+
+	if len(options.Subscriptions) > 0 {
+		request.Subscriptions = to.SlicePtr(options.Subscriptions)
+	}
+
+	if len(options.ManagementGroups) > 0 {
+		request.ManagementGroups = to.SlicePtr(options.ManagementGroups)
+	}
+
+	for {
+		result, err := client.Resources(ctx, request, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, ok := result.Data.([]interface{})
+		if !ok {
+			// empty or invalid result
+			break
+		}
+
+		for _, row := range rows {
+			if rowData, ok := row.(map[string]interface{}); ok {
+				list = append(list, rowData)
+			}
+		}
+
+		if result.SkipToken == nil || *result.SkipToken == "" {
+			break
+		}
+		request.Options.SkipToken = result.SkipToken
+	}
+
+	return list, nil
+}
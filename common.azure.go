@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	commonAzidentity "github.com/webdevops/go-common/azuresdk/azidentity"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-keyvault-exporter/config"
+)
+
+const (
+	AzureDefaultTargetName = "default"
+)
+
+type (
+	AzureTarget struct {
+		Name string
+
+		// cloud config and base client options only, ARM requests are done by the target (own credential and client options)
+		Client             *armclient.ArmClient
+		SubscriptionScope  *AzureSubscriptionScope
+		ResourceTagManager *armclient.ResourceTagManager
+
+		// subscription filter (empty = all subscriptions of the credential)
+		SubscriptionIDs []string
+
+		// static KeyVaults (data-plane only, no discovery)
+		StaticVaults []*KeyVault
+
+		cred     azcore.TokenCredential
+		armCache *azureArmCache
+	}
+)
+
+// NewAzureTarget creates new Azure target (credential, subscription scope and tag manager) from target config
+func NewAzureTarget(conf config.ConfigTarget) (*AzureTarget, error) {
+	var err error
+
+	target := &AzureTarget{
+		Name: conf.Name,
+	}
+
+	environment := conf.Environment
+	if environment == "" {
+		environment = *Opts.Azure.Environment
+	}
+
+	target.Client, err = armclient.NewArmClientWithCloudName(environment, logger.With(zap.String("target", conf.Name)))
+	if err != nil {
+		return nil, err
+	}
+
+	target.Client.SetUserAgent(UserAgent + gitTag)
+
+	if err := target.initCredential(conf); err != nil {
+		return nil, fmt.Errorf(`target "%s": unable to create credential: %w`, conf.Name, err)
+	}
+
+	// limit subscriptions (if filter is set)
+	target.SubscriptionIDs = conf.Subscriptions
+
+	// subscriptions and resourcegroups are cached like the go-common service discovery
+	cacheTtl := armCacheTtlDefault
+	if val := os.Getenv(armclient.EnvVarServiceDiscoveryTtl); val != "" {
+		if cacheTtl, err = time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf(`%s is not a valid duration: %w`, armclient.EnvVarServiceDiscoveryTtl, err)
+		}
+	}
+	target.armCache = newAzureArmCache(cacheTtl)
+
+	// init subscription scope (management groups, name, tags, state)
+	target.SubscriptionScope, err = NewAzureSubscriptionScope(conf.Scope)
+	if err != nil {
+		return nil, fmt.Errorf(`target "%s": %w`, conf.Name, err)
+	}
+
+	// init resource tag manager
+	target.ResourceTagManager, err = target.Client.TagManager.ParseTagConfig(Opts.Azure.ResourceTags)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to parse resourceTag configuration "%s": %w`, Opts.Azure.ResourceTags, err)
+	}
+
+	// init static KeyVaults
+	for _, vaultConfig := range conf.Vaults {
+		vault, err := NewKeyVaultFromConfig(vaultConfig, target.ResourceTagManager.AddToPrometheusLabels([]string{}))
+		if err != nil {
+			return nil, fmt.Errorf(`target "%s": %w`, conf.Name, err)
+		}
+		target.StaticVaults = append(target.StaticVaults, vault)
+	}
+
+	return target, nil
+}
+
+// ListSubscriptions returns list of subscriptions of target (filtered by subscription list and scope)
+func (t *AzureTarget) ListSubscriptions(ctx context.Context) (map[string]*armsubscriptions.Subscription, error) {
+	subscriptionList, err := t.listCachedSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.SubscriptionIDs) > 0 {
+		filteredList := map[string]*armsubscriptions.Subscription{}
+		for subscriptionID, subscription := range subscriptionList {
+			for _, filterSubscriptionID := range t.SubscriptionIDs {
+				if strings.EqualFold(subscriptionID, filterSubscriptionID) {
+					filteredList[subscriptionID] = subscription
+					break
+				}
+			}
+		}
+		subscriptionList = filteredList
+	}
+
+	return t.SubscriptionScope.FilterSubscriptions(ctx, t, subscriptionList)
+}
+
+// GetCred returns credential of target (used for all ARM and KeyVault clients of the target)
+func (t *AzureTarget) GetCred() azcore.TokenCredential {
+	return t.cred
+}
+
+// initCredential creates the credential of the target, only the default credential is created from the environment
+func (t *AzureTarget) initCredential(conf config.ConfigTarget) error {
+	var (
+		cred azcore.TokenCredential
+		err  error
+	)
+
+	clientOptions := *t.Client.NewAzCoreClientOptions()
+
+	switch conf.Credential.Type {
+	case config.CredentialTypeDefault, "":
+		// DefaultAzureCredential from process environment (or az cli if AZURE_AUTH=az)
+		cred, err = commonAzidentity.NewAzDefaultCredential(&clientOptions)
+	case config.CredentialTypeClientSecret:
+		cred, err = azidentity.NewClientSecretCredential(
+			conf.Tenant,
+			conf.Credential.ClientID,
+			conf.Credential.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions},
+		)
+	case config.CredentialTypeClientCertificate:
+		certData, readErr := os.ReadFile(filepath.Clean(conf.Credential.CertificatePath))
+		if readErr != nil {
+			return fmt.Errorf(`unable to read certificate "%s": %w`, conf.Credential.CertificatePath, readErr)
+		}
+
+		var password []byte
+		if conf.Credential.CertificatePassword != "" {
+			password = []byte(conf.Credential.CertificatePassword)
+		}
+
+		certs, key, parseErr := azidentity.ParseCertificates(certData, password)
+		if parseErr != nil {
+			return fmt.Errorf(`unable to parse certificate "%s": %w`, conf.Credential.CertificatePath, parseErr)
+		}
+
+		cred, err = azidentity.NewClientCertificateCredential(
+			conf.Tenant,
+			conf.Credential.ClientID,
+			certs,
+			key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions},
+		)
+	case config.CredentialTypeWorkloadIdentity:
+		tokenFile := conf.Credential.FederatedTokenFile
+		if tokenFile == "" {
+			// token file is injected by the workload identity webhook
+			tokenFile = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+		}
+
+		cred, err = azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      conf.Tenant,
+			ClientID:      conf.Credential.ClientID,
+			TokenFilePath: tokenFile,
+		})
+	case config.CredentialTypeManagedIdentity:
+		opts := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if conf.Credential.ClientID != "" {
+			// user assigned identity
+			opts.ID = azidentity.ClientID(conf.Credential.ClientID)
+		}
+		cred, err = azidentity.NewManagedIdentityCredential(opts)
+	default:
+		return fmt.Errorf(`credential type "%s" is not supported`, conf.Credential.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.cred = cred
+	return nil
+}
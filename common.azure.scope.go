@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/webdevops/azure-keyvault-exporter/config"
+)
+
+type (
+	AzureSubscriptionScope struct {
+		ManagementGroups []string
+		NameRegExp       *regexp.Regexp
+		TagSelector      labels.Selector
+		States           []string
+	}
+)
+
+// NewAzureSubscriptionScope creates subscription scope from config, unset values are taken from args
+func NewAzureSubscriptionScope(conf config.ConfigTargetScope) (*AzureSubscriptionScope, error) {
+	scope := &AzureSubscriptionScope{}
+
+	scope.ManagementGroups = conf.ManagementGroups
+	if len(scope.ManagementGroups) == 0 {
+		scope.ManagementGroups = Opts.Azure.ManagementGroup
+	}
+
+	subscriptionName := conf.SubscriptionName
+	if subscriptionName == "" {
+		subscriptionName = Opts.Azure.SubscriptionName
+	}
+	if subscriptionName != "" {
+		regExp, err := regexp.Compile(subscriptionName)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid subscription name regex "%s": %w`, subscriptionName, err)
+		}
+		scope.NameRegExp = regExp
+	}
+
+	tagSelector := conf.SubscriptionTagSelector
+	if tagSelector == "" {
+		tagSelector = Opts.Azure.SubscriptionTagSelector
+	}
+	if tagSelector != "" {
+		selector, err := labels.Parse(tagSelector)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid subscription tag selector "%s": %w`, tagSelector, err)
+		}
+		scope.TagSelector = selector
+	}
+
+	scope.States = conf.SubscriptionState
+	if len(scope.States) == 0 {
+		scope.States = Opts.Azure.SubscriptionState
+	}
+
+	return scope, nil
+}
+
+// IsEnabled returns true if any subscription scope filter is set
+func (s *AzureSubscriptionScope) IsEnabled() bool {
+	return len(s.ManagementGroups) > 0 || s.NameRegExp != nil || s.TagSelector != nil || len(s.States) > 0
+}
+
+// FilterSubscriptions filters list of subscriptions by scope
+func (s *AzureSubscriptionScope) FilterSubscriptions(ctx context.Context, target *AzureTarget, list map[string]*armsubscriptions.Subscription) (map[string]*armsubscriptions.Subscription, error) {
+	if !s.IsEnabled() {
+		return list, nil
+	}
+
+	var managementGroupSubscriptions map[string]bool
+	if len(s.ManagementGroups) > 0 {
+		var err error
+		managementGroupSubscriptions, err = s.listManagementGroupSubscriptions(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := map[string]*armsubscriptions.Subscription{}
+	for subscriptionID, subscription := range list {
+		if managementGroupSubscriptions != nil {
+			if _, exists := managementGroupSubscriptions[strings.ToLower(subscriptionID)]; !exists {
+				continue
+			}
+		}
+
+		if s.NameRegExp != nil && !s.NameRegExp.MatchString(to.String(subscription.DisplayName)) {
+			continue
+		}
+
+		if s.TagSelector != nil && !s.TagSelector.Matches(labels.Set(to.StringMap(subscription.Tags))) {
+			continue
+		}
+
+		if len(s.States) > 0 {
+			stateMatch := false
+			if subscription.State != nil {
+				for _, state := range s.States {
+					if strings.EqualFold(state, string(*subscription.State)) {
+						stateMatch = true
+						break
+					}
+				}
+			}
+
+			if !stateMatch {
+				continue
+			}
+		}
+
+		ret[subscriptionID] = subscription
+	}
+
+	return ret, nil
+}
+
+// listManagementGroupSubscriptions returns all subscriptions (recursive) below the management groups using ResourceGraph
+func (s *AzureSubscriptionScope) listManagementGroupSubscriptions(ctx context.Context, target *AzureTarget) (map[string]bool, error) {
+	ret := map[string]bool{}
+
+	query := `resourcecontainers
+| where type =~ "microsoft.resources/subscriptions"
+| project subscriptionId`
+
+	opts := armclient.ResourceGraphOptions{
+		ManagementGroups: s.ManagementGroups,
+	}
+	result, err := target.ExecuteResourceGraphQuery(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to list subscriptions of management groups "%s": %w`, strings.Join(s.ManagementGroups, ", "), err)
+	}
+
+	for _, row := range result {
+		if val, ok := row["subscriptionId"].(string); ok {
+			ret[strings.ToLower(val)] = true
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+)
+
+const (
+	HealthCredentialStatusOk    = "ok"
+	HealthCredentialStatusError = "error"
+
+	// credential token checks are cached to not request a token on every status request
+	healthCredentialCheckTtl     = 1 * time.Minute
+	healthCredentialCheckTimeout = 5 * time.Second
+
+	// max age of last successful collection (if not set) as multiple of the collector scrape time
+	healthMaxAgeScrapeTimeFactor = 3
+)
+
+var (
+	// health (readiness and status) of all collectors, subscriptions, KeyVaults and credentials
+	exporterHealth = &ExporterHealth{
+		collectors:    map[string]*HealthCollectorStatus{},
+		subscriptions: map[string]*HealthStatus{},
+		vaults:        map[string]*HealthStatus{},
+		credentials:   map[string]*HealthCredentialStatus{},
+	}
+)
+
+type (
+	// ExporterHealth tracks the last successful collections, the exporter is ready after the first successful
+	// collection of all collectors as long as their data is not older than --server.readyz.maxage
+	ExporterHealth struct {
+		collectors    map[string]*HealthCollectorStatus
+		subscriptions map[string]*HealthStatus
+		vaults        map[string]*HealthStatus
+		credentials   map[string]*HealthCredentialStatus
+		lock          sync.RWMutex
+	}
+
+	HealthCollectorStatus struct {
+		ScrapeTime    string     `json:"scrapeTime"`
+		LastRun       *time.Time `json:"lastRun,omitempty"`
+		LastSuccess   *time.Time `json:"lastSuccess,omitempty"`
+		Restored      bool       `json:"restoredFromCache"`
+		TargetErrors  int64      `json:"targetErrors"`
+		VaultsSuccess int64      `json:"vaultsSuccess"`
+		VaultsFailed  int64      `json:"vaultsFailed"`
+
+		scrapeTime time.Duration
+
+		// replica is not the leader, collector is not running
+		standby bool
+	}
+
+	HealthStatus struct {
+		Target            string     `json:"target"`
+		ID                string     `json:"id"`
+		Name              string     `json:"name"`
+		LastSuccess       *time.Time `json:"lastSuccess,omitempty"`
+		LastError         *time.Time `json:"lastError,omitempty"`
+		LastErrorReason   string     `json:"lastErrorReason,omitempty"`
+		Errors            int64      `json:"errors"`
+		ConsecutiveErrors int64      `json:"consecutiveErrors"`
+
+		lastSeen time.Time
+	}
+
+	HealthCredentialStatus struct {
+		Target    string     `json:"target"`
+		Status    string     `json:"status"`
+		ExpiresOn *time.Time `json:"expiresOn,omitempty"`
+		Error     string     `json:"error,omitempty"`
+		CheckedAt time.Time  `json:"checkedAt"`
+	}
+)
+
+// registerCollector adds an enabled collector, readiness waits for its first successful collection
+func (h *ExporterHealth) registerCollector(name string, scrapeTime time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.collectors[name] = &HealthCollectorStatus{
+		ScrapeTime: scrapeTime.String(),
+		scrapeTime: scrapeTime,
+	}
+}
+
+// collectorRestored marks metrics of collector as restored from cache (created at cacheTime)
+func (h *ExporterHealth) collectorRestored(name string, cacheTime *time.Time) {
+	if cacheTime == nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if status, exists := h.collectors[name]; exists {
+		lastSuccess := *cacheTime
+		status.LastSuccess = &lastSuccess
+		status.Restored = true
+	}
+}
+
+// collectorStandby marks collector as not running because this replica is not the leader
+func (h *ExporterHealth) collectorStandby(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if status, exists := h.collectors[name]; exists {
+		status.standby = true
+	}
+}
+
+// collectorFinished stores result of collector run, a run is successful if all KeyVaults were discovered
+// and at least one KeyVault was collected (or none failed)
+func (h *ExporterHealth) collectorFinished(name string, run *keyvaultCollectorRun) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	status, exists := h.collectors[name]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	status.standby = false
+	status.LastRun = &now
+	status.TargetErrors = run.targetErrors.Load()
+	status.VaultsSuccess = run.vaultsSuccess.Load()
+	status.VaultsFailed = run.vaultsFailed.Load()
+
+	if status.TargetErrors == 0 && (status.VaultsSuccess > 0 || status.VaultsFailed == 0) {
+		status.LastSuccess = &now
+		status.Restored = false
+	}
+
+	// cleanup KeyVaults and subscriptions which were not discovered anymore
+	for key, entry := range h.vaults {
+		if time.Since(entry.lastSeen) > keyvaultLastSuccessRetention {
+			delete(h.vaults, key)
+		}
+	}
+	for key, entry := range h.subscriptions {
+		if time.Since(entry.lastSeen) > keyvaultLastSuccessRetention {
+			delete(h.subscriptions, key)
+		}
+	}
+}
+
+// subscription stores discovery result of subscription
+func (h *ExporterHealth) subscription(target *AzureTarget, subscription *armsubscriptions.Subscription, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := target.Name + ":" + strings.ToLower(*subscription.SubscriptionID)
+	if _, exists := h.subscriptions[key]; !exists {
+		h.subscriptions[key] = &HealthStatus{
+			Target: target.Name,
+			ID:     *subscription.SubscriptionID,
+		}
+	}
+
+	entry := h.subscriptions[key]
+	if subscription.DisplayName != nil {
+		entry.Name = *subscription.DisplayName
+	}
+	entry.update(err == nil, err)
+}
+
+// vault stores content collection result of KeyVault
+func (h *ExporterHealth) vault(target *AzureTarget, vault *KeyVault, success bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := target.Name + ":" + vault.ResourceID
+	if _, exists := h.vaults[key]; !exists {
+		h.vaults[key] = &HealthStatus{
+			Target: target.Name,
+			ID:     vault.ResourceID,
+			Name:   vault.Name,
+		}
+	}
+
+	h.vaults[key].update(success, nil)
+}
+
+// update stores success or failure (err is optional and only used for the error reason)
+func (s *HealthStatus) update(success bool, err error) {
+	now := time.Now()
+	s.lastSeen = now
+
+	if success {
+		s.LastSuccess = &now
+		s.ConsecutiveErrors = 0
+		return
+	}
+
+	s.LastError = &now
+	s.Errors++
+	s.ConsecutiveErrors++
+	if err != nil {
+		s.LastErrorReason = collectErrorReason(err)
+	}
+}
+
+// Ready returns if exporter is ready, otherwise the reason is returned. Collectors on standby (follower) are not checked,
+// readiness doesn't depend on the leader election role (reported in /status)
+func (h *ExporterHealth) Ready() (bool, string) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	names := []string{}
+	for name := range h.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		status := h.collectors[name]
+		if status.standby {
+			continue
+		}
+
+		if status.LastSuccess == nil {
+			return false, fmt.Sprintf(`collector "%s": waiting for first successful collection`, name)
+		}
+
+		maxAge := Opts.Server.ReadyMaxAge
+		if maxAge <= 0 {
+			maxAge = status.scrapeTime * healthMaxAgeScrapeTimeFactor
+		}
+
+		if age := time.Since(*status.LastSuccess); age > maxAge {
+			return false, fmt.Sprintf(`collector "%s": last successful collection %s ago (max age %s)`, name, age.Round(time.Second), maxAge)
+		}
+	}
+
+	return true, ""
+}
+
+// checkCredentials requests a token for each target (cached) to detect broken credentials
+func (h *ExporterHealth) checkCredentials(ctx context.Context) {
+	for _, target := range AzureTargets {
+		h.lock.RLock()
+		status, exists := h.credentials[target.Name]
+		h.lock.RUnlock()
+
+		if exists && time.Since(status.CheckedAt) < healthCredentialCheckTtl {
+			continue
+		}
+
+		status = &HealthCredentialStatus{
+			Target:    target.Name,
+			Status:    HealthCredentialStatusOk,
+			CheckedAt: time.Now(),
+		}
+
+		func() {
+			tokenCtx, cancel := context.WithTimeout(ctx, healthCredentialCheckTimeout)
+			defer cancel()
+
+			audience := target.Client.GetCloudConfig().Services[cloud.ResourceManager].Audience
+			token, err := target.GetCred().GetToken(tokenCtx, policy.TokenRequestOptions{
+				Scopes: []string{strings.TrimSuffix(audience, "/") + "/.default"},
+			})
+			if err != nil {
+				status.Status = HealthCredentialStatusError
+				status.Error = err.Error()
+				return
+			}
+
+			expiresOn := token.ExpiresOn
+			status.ExpiresOn = &expiresOn
+		}()
+
+		h.lock.Lock()
+		h.credentials[target.Name] = status
+		h.lock.Unlock()
+	}
+}
+
+// handleStatus returns health of exporter as JSON (collectors, credentials, subscriptions and KeyVaults)
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	exporterHealth.checkCredentials(r.Context())
+
+	ready, reason := exporterHealth.Ready()
+
+	exporterHealth.lock.RLock()
+	defer exporterHealth.lock.RUnlock()
+
+	status := struct {
+		Ready         bool                              `json:"ready"`
+		Reason        string                            `json:"reason,omitempty"`
+		Leader        bool                              `json:"leader"`
+		Collectors    map[string]*HealthCollectorStatus `json:"collectors"`
+		Credentials   []*HealthCredentialStatus         `json:"credentials"`
+		Subscriptions []*HealthStatus                   `json:"subscriptions"`
+		Vaults        []*HealthStatus                   `json:"vaults"`
+	}{
+		Ready:         ready,
+		Reason:        reason,
+		Leader:        leaderElection.IsLeader(),
+		Collectors:    exporterHealth.collectors,
+		Credentials:   []*HealthCredentialStatus{},
+		Subscriptions: []*HealthStatus{},
+		Vaults:        []*HealthStatus{},
+	}
+
+	for _, entry := range exporterHealth.credentials {
+		status.Credentials = append(status.Credentials, entry)
+	}
+	for _, entry := range exporterHealth.subscriptions {
+		status.Subscriptions = append(status.Subscriptions, entry)
+	}
+	for _, entry := range exporterHealth.vaults {
+		status.Vaults = append(status.Vaults, entry)
+	}
+
+	sort.Slice(status.Credentials, func(i, j int) bool {
+		return status.Credentials[i].Target < status.Credentials[j].Target
+	})
+	sortHealthStatus(status.Subscriptions)
+	sortHealthStatus(status.Vaults)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(status); err != nil {
+		logger.Error(err)
+	}
+}
+
+// sortHealthStatus sorts by target and name
+func sortHealthStatus(list []*HealthStatus) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Target != list[j].Target {
+			return list[i].Target < list[j].Target
+		}
+		return list[i].Name < list[j].Name
+	})
+}
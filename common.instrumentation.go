@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// scope of whole KeyVault collection (other scopes: keys, secrets, certificates)
+	InstrumentationScopeVault = "vault"
+
+	InstrumentationResultSuccess = "success"
+	InstrumentationResultFailed  = "failed"
+	InstrumentationResultSkipped = "skipped"
+
+	InstrumentationApiKeyVault = "keyvault"
+	InstrumentationApiArm      = "arm"
+)
+
+var (
+	// path segments of KeyVault data-plane requests which are kept in the operation label (other segments are item names/versions)
+	instrumentationOperationSegments = map[string]bool{
+		"keys":           true,
+		"secrets":        true,
+		"certificates":   true,
+		"deletedkeys":    true,
+		"deletedsecrets": true,
+		"issuers":        true,
+		"contacts":       true,
+		"policy":         true,
+		"pending":        true,
+		"versions":       true,
+		"rotationpolicy": true,
+	}
+
+	prometheusVaultDuration *prometheus.HistogramVec
+	prometheusApiRequests   *prometheus.CounterVec
+	prometheusApiDuration   *prometheus.HistogramVec
+	prometheusListPages     *prometheus.HistogramVec
+	prometheusListItems     *prometheus.HistogramVec
+	prometheusCycleDuration *prometheus.HistogramVec
+	prometheusCycleVaults   *prometheus.GaugeVec
+)
+
+type (
+	// azureApiMetricsPolicy is an azcore pipeline policy which counts and times KeyVault data-plane or ARM requests (incl. retries)
+	azureApiMetricsPolicy struct {
+		api string
+	}
+)
+
+func init() {
+	prometheusVaultDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_keyvault_collect_vault_duration_seconds",
+			Help:    "Azure KeyVault exporter: collection duration per KeyVault and scope",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"collector", "scope"},
+	)
+	prometheus.MustRegister(prometheusVaultDuration)
+
+	prometheusApiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_api_requests_total",
+			Help: "Azure KeyVault exporter: KeyVault data-plane and ARM requests (incl. retries)",
+		},
+		[]string{"api", "operation", "statusCode"},
+	)
+	prometheus.MustRegister(prometheusApiRequests)
+
+	prometheusApiDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_keyvault_api_request_duration_seconds",
+			Help:    "Azure KeyVault exporter: KeyVault data-plane and ARM request duration (incl. retries)",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"api", "operation"},
+	)
+	prometheus.MustRegister(prometheusApiDuration)
+
+	prometheusListPages = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_keyvault_collect_list_pages",
+			Help:    "Azure KeyVault exporter: pages fetched per listing (pager) of KeyVault scope",
+			Buckets: []float64{1, 2, 5, 10, 25, 50, 100},
+		},
+		[]string{"collector", "scope"},
+	)
+	prometheus.MustRegister(prometheusListPages)
+
+	prometheusListItems = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_keyvault_collect_list_items",
+			Help:    "Azure KeyVault exporter: items per KeyVault scope",
+			Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000},
+		},
+		[]string{"collector", "scope"},
+	)
+	prometheus.MustRegister(prometheusListItems)
+
+	prometheusCycleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_keyvault_collect_cycle_duration_seconds",
+			Help:    "Azure KeyVault exporter: duration of collection cycle (all KeyVaults)",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+		},
+		[]string{"collector"},
+	)
+	prometheus.MustRegister(prometheusCycleDuration)
+
+	prometheusCycleVaults = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_collect_cycle_vaults",
+			Help: "Azure KeyVault exporter: KeyVaults of last collection cycle by result (success, failed, skipped: not due)",
+		},
+		[]string{"collector", "result"},
+	)
+	prometheus.MustRegister(prometheusCycleVaults)
+}
+
+// observeCollectorRun exports duration and KeyVault results of collector run
+func observeCollectorRun(collectorName string, run *keyvaultCollectorRun) {
+	if run == nil {
+		return
+	}
+
+	prometheusCycleDuration.WithLabelValues(collectorName).Observe(time.Since(run.startTime).Seconds())
+	prometheusCycleVaults.WithLabelValues(collectorName, InstrumentationResultSuccess).Set(float64(run.vaultsSuccess.Load()))
+	prometheusCycleVaults.WithLabelValues(collectorName, InstrumentationResultFailed).Set(float64(run.vaultsFailed.Load()))
+	prometheusCycleVaults.WithLabelValues(collectorName, InstrumentationResultSkipped).Set(float64(run.vaultsSkipped.Load()))
+}
+
+// observeVaultDuration records collection duration of KeyVault scope (not for probe requests)
+func (m *MetricsCollectorKeyvaultBase) observeVaultDuration(scope string, duration time.Duration) {
+	if m.metricLists != nil {
+		return
+	}
+
+	prometheusVaultDuration.WithLabelValues(m.Collector.Name, scope).Observe(duration.Seconds())
+}
+
+// observeListing records pages and items of a KeyVault scope listing (not for probe requests)
+func (m *MetricsCollectorKeyvaultBase) observeListing(scope string, pages, items int) {
+	if m.metricLists != nil {
+		return
+	}
+
+	prometheusListPages.WithLabelValues(m.Collector.Name, scope).Observe(float64(pages))
+	prometheusListItems.WithLabelValues(m.Collector.Name, scope).Observe(float64(items))
+}
+
+// Do implements azcore policy
+func (p *azureApiMetricsPolicy) Do(req *policy.Request) (*http.Response, error) {
+	startTime := time.Now()
+	resp, err := req.Next()
+
+	operation := ""
+	switch p.api {
+	case InstrumentationApiArm:
+		operation = instrumentationArmOperation(req.Raw())
+	default:
+		operation = instrumentationOperation(req.Raw())
+	}
+
+	statusCode := ""
+	if resp != nil {
+		statusCode = strconv.Itoa(resp.StatusCode)
+	}
+	prometheusApiRequests.WithLabelValues(p.api, operation, statusCode).Inc()
+	prometheusApiDuration.WithLabelValues(p.api, operation).Observe(time.Since(startTime).Seconds())
+
+	return resp, err
+}
+
+// instrumentationOperation returns operation of KeyVault data-plane request with item names and versions
+// replaced by placeholders (eg. "GET /keys/{name}/{version}")
+func instrumentationOperation(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if !instrumentationOperationSegments[strings.ToLower(segment)] {
+			segments[i] = "{name}"
+			if i > 0 && segments[i-1] == "{name}" {
+				segments[i] = "{version}"
+			}
+		} else {
+			segments[i] = strings.ToLower(segment)
+		}
+	}
+
+	return req.Method + " /" + strings.Join(segments, "/")
+}
+
+// instrumentationArmOperation returns operation of ARM request with resource names replaced by placeholders, ARM paths
+// alternate between resource type and name (eg. "GET /subscriptions/{name}/resourcegroups/{name}/providers/microsoft.keyvault/vaults")
+func instrumentationArmOperation(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	expectName, expectNamespace := false, false
+	for i, segment := range segments {
+		switch {
+		case expectNamespace:
+			// resource provider namespace (eg. Microsoft.KeyVault)
+			segments[i] = strings.ToLower(segment)
+			expectNamespace = false
+		case expectName:
+			segments[i] = "{name}"
+			expectName = false
+		case strings.EqualFold(segment, "providers"):
+			segments[i] = "providers"
+			expectNamespace = true
+		default:
+			segments[i] = strings.ToLower(segment)
+			expectName = true
+		}
+	}
+
+	return req.Method + " /" + strings.Join(segments, "/")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestInstrumentationOperation(t *testing.T) {
+	tests := []struct {
+		method    string
+		url       string
+		operation string
+	}{
+		{method: http.MethodGet, url: "https://kv-a.vault.azure.net/keys", operation: "GET /keys"},
+		{method: http.MethodGet, url: "https://kv-a.vault.azure.net/keys/my-key/0123456789abcdef", operation: "GET /keys/{name}/{version}"},
+		{method: http.MethodGet, url: "https://kv-a.vault.azure.net/certificates/my-cert/policy", operation: "GET /certificates/{name}/policy"},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if operation := instrumentationOperation(req); operation != test.operation {
+			t.Errorf(`expected operation "%s" for "%s", got "%s"`, test.operation, test.url, operation)
+		}
+	}
+}
+
+func TestInstrumentationArmOperation(t *testing.T) {
+	tests := []struct {
+		method    string
+		url       string
+		operation string
+	}{
+		{method: http.MethodGet, url: "https://management.azure.com/subscriptions?api-version=2022-12-01", operation: "GET /subscriptions"},
+		{method: http.MethodGet, url: "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups", operation: "GET /subscriptions/{name}/resourcegroups"},
+		{method: http.MethodGet, url: "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-a/providers/Microsoft.KeyVault/vaults/kv-a", operation: "GET /subscriptions/{name}/resourcegroups/{name}/providers/microsoft.keyvault/vaults/{name}"},
+		{method: http.MethodGet, url: "https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000001/providers/Microsoft.KeyVault/vaults", operation: "GET /subscriptions/{name}/providers/microsoft.keyvault/vaults"},
+		{method: http.MethodPost, url: "https://management.azure.com/providers/Microsoft.ResourceGraph/resources", operation: "POST /providers/microsoft.resourcegraph/resources"},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if operation := instrumentationArmOperation(req); operation != test.operation {
+			t.Errorf(`expected operation "%s" for "%s", got "%s"`, test.operation, test.url, operation)
+		}
+	}
+}
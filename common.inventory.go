@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/remeh/sizedwaitgroup"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	KeyVaultDiscoveryArm           = "arm"
+	KeyVaultDiscoveryResourceGraph = "resourcegraph"
+)
+
+var (
+	// shared KeyVault discovery results of all collectors
+	keyvaultInventory = &KeyVaultInventory{
+		targets: map[string]*keyvaultInventoryTarget{},
+	}
+)
+
+type (
+	// KeyVaultInventory contains the discovered KeyVaults per Azure target
+	KeyVaultInventory struct {
+		targets map[string]*keyvaultInventoryTarget
+		lock    sync.Mutex
+	}
+
+	keyvaultInventoryTarget struct {
+		vaults []*KeyVault
+		time   time.Time
+		lock   sync.Mutex
+	}
+)
+
+// target returns inventory of Azure target (created if not exists)
+func (i *KeyVaultInventory) target(target *AzureTarget) *keyvaultInventoryTarget {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, exists := i.targets[target.Name]; !exists {
+		i.targets[target.Name] = &keyvaultInventoryTarget{}
+	}
+
+	return i.targets[target.Name]
+}
+
+// Vaults returns KeyVaults of target, if the inventory collector is enabled the last discovery result is used
+// (kept up to date by the inventory collector), otherwise KeyVaults are discovered on each call
+func (i *KeyVaultInventory) Vaults(ctx context.Context, target *AzureTarget, logger *zap.SugaredLogger) ([]*KeyVault, error) {
+	entry := i.target(target)
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if Opts.Scrape.TimeInventory > 0 && !entry.time.IsZero() {
+		return entry.vaults, nil
+	}
+
+	return entry.discover(ctx, target, logger)
+}
+
+// Refresh discovers KeyVaults of target and updates the inventory
+func (i *KeyVaultInventory) Refresh(ctx context.Context, target *AzureTarget, logger *zap.SugaredLogger) ([]*KeyVault, error) {
+	entry := i.target(target)
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	return entry.discover(ctx, target, logger)
+}
+
+// discover discovers KeyVaults of target (only KeyVaults of this shard are kept), if discovery fails the last known KeyVaults are returned with the error
+func (e *keyvaultInventoryTarget) discover(ctx context.Context, target *AzureTarget, logger *zap.SugaredLogger) ([]*KeyVault, error) {
+	ctx, span := tracer.Start(ctx, "discoverKeyVaults", trace.WithAttributes(attribute.String("target", target.Name)))
+	vaults, err := discoverKeyVaults(ctx, target, logger)
+	tracingEnd(span, err)
+	if err != nil {
+		return e.vaults, err
+	}
+
+	e.vaults = keyvaultSharding.Filter(target, vaults)
+	e.time = time.Now()
+	return e.vaults, nil
+}
+
+// discoverKeyVaults discovers all KeyVaults of target, errors of subscriptions are counted and logged there
+func discoverKeyVaults(ctx context.Context, target *AzureTarget, logger *zap.SugaredLogger) (vaults []*KeyVault, err error) {
+	var filterResourceIdMap *map[string]string
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = &collectPanicError{value: r}
+		}
+	}()
+
+	if len(target.StaticVaults) > 0 {
+		// static KeyVaults, data-plane only (no ARM/subscription lookups)
+		return target.StaticVaults, nil
+	}
+
+	// get list of subscriptions (filtered by subscription scope)
+	subscriptionList, err := target.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to list subscriptions: %w`, err)
+	}
+
+	if Opts.KeyVault.Discovery == KeyVaultDiscoveryResourceGraph {
+		return discoverKeyVaultsWithResourceGraph(ctx, target, subscriptionList, logger)
+	}
+
+	if len(Opts.KeyVault.Filter) > 0 {
+		filterSubscriptions := []string{}
+		for _, subscription := range subscriptionList {
+			filterSubscriptions = append(filterSubscriptions, *subscription.SubscriptionID)
+		}
+
+		query := "resources \n| where type =~ \"microsoft.keyvault/vaults\" \n"
+		if filter := strings.TrimLeft(strings.TrimSpace(Opts.KeyVault.Filter), "|"); len(filter) > 0 {
+			query += fmt.Sprintf("| %s \n", filter)
+		}
+		query += "| project id"
+
+		// get list of resourceids based on kusto query
+		opts := armclient.ResourceGraphOptions{
+			Subscriptions: filterSubscriptions,
+		}
+		result, err := target.ExecuteResourceGraphQuery(ctx, query, opts)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to apply KeyVault filter: %w`, err)
+		}
+
+		resourceIdMap := map[string]string{}
+		for _, row := range result {
+			if val, ok := row["id"].(string); ok {
+				resourceIdMap[strings.ToLower(val)] = strings.ToLower(val)
+			}
+		}
+		filterResourceIdMap = &resourceIdMap
+	}
+
+	vaultsLock := sync.Mutex{}
+	wg := sizedwaitgroup.New(armclient.IteratorDefaultConcurrency)
+	for _, subscription := range subscriptionList {
+		wg.Add()
+		go func(subscription *armsubscriptions.Subscription) {
+			defer wg.Done()
+
+			logger := logger.With(
+				zap.String("subscriptionID", to.String(subscription.SubscriptionID)),
+				zap.String("subscriptionName", to.String(subscription.DisplayName)),
+			)
+
+			subscriptionCtx, span := tracer.Start(ctx, "collectSubscription", trace.WithAttributes(
+				attribute.String("target", target.Name),
+				attribute.String("subscriptionID", *subscription.SubscriptionID),
+			))
+			subscriptionVaults, err := discoverSubscriptionKeyVaults(subscriptionCtx, target, subscription, tracingLogger(subscriptionCtx, logger), filterResourceIdMap)
+			tracingEnd(span, err)
+			exporterHealth.subscription(target, subscription, err)
+			if err != nil {
+				countCollectError(CollectErrorScopeSubscription, err)
+				logger.Error(err)
+			}
+
+			vaultsLock.Lock()
+			vaults = append(vaults, subscriptionVaults...)
+			vaultsLock.Unlock()
+		}(subscription)
+	}
+	wg.Wait()
+
+	return vaults, nil
+}
+
+// discoverKeyVaultsWithResourceGraph discovers all KeyVaults of target using one (paged) ResourceGraph query
+func discoverKeyVaultsWithResourceGraph(ctx context.Context, target *AzureTarget, subscriptionList map[string]*armsubscriptions.Subscription, logger *zap.SugaredLogger) ([]*KeyVault, error) {
+	vaults := []*KeyVault{}
+
+	if len(subscriptionList) == 0 {
+		return vaults, nil
+	}
+
+	subscriptionMap := map[string]*armsubscriptions.Subscription{}
+	filterSubscriptions := []string{}
+	for _, subscription := range subscriptionList {
+		subscriptionMap[to.StringLower(subscription.SubscriptionID)] = subscription
+		filterSubscriptions = append(filterSubscriptions, *subscription.SubscriptionID)
+	}
+
+	query := "resources \n| where type =~ \"microsoft.keyvault/vaults\" \n"
+	if filter := strings.TrimLeft(strings.TrimSpace(Opts.KeyVault.Filter), "|"); len(filter) > 0 {
+		query += fmt.Sprintf("| %s \n", filter)
+	}
+	query += "| project id, name, type, location, tags, properties"
+
+	opts := armclient.ResourceGraphOptions{
+		Subscriptions: filterSubscriptions,
+	}
+	result, err := target.ExecuteResourceGraphQuery(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to discover KeyVaults using ResourceGraph: %w`, err)
+	}
+
+	for _, row := range result {
+		// convert ResourceGraph row to ARM vault object
+		keyvault := &armkeyvault.Vault{}
+		if data, err := json.Marshal(row); err == nil {
+			if err := json.Unmarshal(data, keyvault); err != nil {
+				logger.Warnf(`unable to parse ResourceGraph result: %v`, err)
+				continue
+			}
+		} else {
+			logger.Warnf(`unable to parse ResourceGraph result: %v`, err)
+			continue
+		}
+
+		if keyvault.ID == nil || keyvault.Properties == nil {
+			continue
+		}
+
+		azureResource, err := armclient.ParseResourceId(*keyvault.ID)
+		if err != nil {
+			logger.Warnf(`unable to parse resource id "%s": %v`, *keyvault.ID, err)
+			continue
+		}
+
+		subscription, exists := subscriptionMap[strings.ToLower(azureResource.Subscription)]
+		if !exists {
+			continue
+		}
+
+		vaults = append(vaults, NewKeyVaultFromArm(subscription, keyvault))
+	}
+
+	return vaults, nil
+}
+
+// discoverSubscriptionKeyVaults discovers all KeyVaults of subscription, on errors the KeyVaults found so far are returned
+func discoverSubscriptionKeyVaults(ctx context.Context, target *AzureTarget, subscription *armsubscriptions.Subscription, logger *zap.SugaredLogger, filterResourceIdMap *map[string]string) (vaults []*KeyVault, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &collectPanicError{value: r}
+		}
+	}()
+
+	keyvaultClient, err := armkeyvault.NewVaultsClient(*subscription.SubscriptionID, target.GetCred(), target.NewArmClientOptions())
+	if err != nil {
+		return nil, fmt.Errorf(`unable to create KeyVault client: %w`, err)
+	}
+
+	pager := keyvaultClient.NewListBySubscriptionPager(nil)
+
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return vaults, fmt.Errorf(`unable to list KeyVaults: %w`, err)
+		}
+
+		if result.Value == nil {
+			continue
+		}
+
+		for _, row := range result.Value {
+			keyvault := row
+
+			if filterResourceIdMap != nil {
+				// filter is active, check if resourceid was found earlier using $filter list call
+				resourceId := to.StringLower(keyvault.ID)
+				if _, exists := (*filterResourceIdMap)[resourceId]; !exists {
+					logger.Debugf(`ignoring %v, not matching keyvault filter`, resourceId)
+					continue
+				}
+			}
+
+			vaults = append(vaults, NewKeyVaultFromArm(subscription, keyvault))
+		}
+	}
+
+	return vaults, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-keyvault-exporter/config"
+)
+
+type (
+	// KeyVault contains all information about a KeyVault needed for collection
+	KeyVault struct {
+		ResourceID       string
+		Name             string
+		URL              string
+		Location         string
+		ResourceGroup    string
+		SubscriptionID   string
+		SubscriptionName string
+		TenantID         string
+
+		// SKU and network settings (ARM/ResourceGraph discovery only)
+		Sku                  string
+		PublicNetworkAccess  string
+		NetworkDefaultAction string
+
+		// resource tags of discovery result, used for resource tag labels (no additional ARM lookups)
+		Tags map[string]*string
+
+		// static KeyVault (configured by url), no ARM lookups are done
+		Static bool
+
+		// static labels for info metric (static KeyVaults only)
+		Labels map[string]string
+	}
+)
+
+var (
+	// info metric labels which can be set for static KeyVaults
+	keyvaultStaticLabels = []string{
+		"tenantID",
+		"subscriptionID",
+		"subscriptionName",
+		"resourceGroup",
+		"location",
+	}
+)
+
+// NewKeyVaultFromArm creates KeyVault from ARM vault object
+func NewKeyVaultFromArm(subscription *armsubscriptions.Subscription, vault *armkeyvault.Vault) *KeyVault {
+	resourceID := to.StringLower(vault.ID)
+	azureResource, _ := armclient.ParseResourceId(resourceID)
+
+	ret := &KeyVault{
+		ResourceID:       resourceID,
+		Name:             azureResource.ResourceName,
+		Location:         to.String(vault.Location),
+		ResourceGroup:    azureResource.ResourceGroup,
+		SubscriptionID:   azureResource.Subscription,
+		SubscriptionName: to.String(subscription.DisplayName),
+		TenantID:         to.String(subscription.TenantID),
+		Tags:             vault.Tags,
+	}
+
+	if vault.Properties != nil {
+		ret.URL = to.String(vault.Properties.VaultURI)
+		ret.PublicNetworkAccess = to.String(vault.Properties.PublicNetworkAccess)
+
+		if vault.Properties.SKU != nil && vault.Properties.SKU.Name != nil {
+			ret.Sku = string(*vault.Properties.SKU.Name)
+		}
+
+		if vault.Properties.NetworkACLs != nil && vault.Properties.NetworkACLs.DefaultAction != nil {
+			ret.NetworkDefaultAction = string(*vault.Properties.NetworkACLs.DefaultAction)
+		}
+	}
+
+	return ret
+}
+
+// NewKeyVaultFromConfig creates static KeyVault from config, resourceTagLabels are the allowed tag labels
+func NewKeyVaultFromConfig(conf config.ConfigVault, resourceTagLabels []string) (*KeyVault, error) {
+	vaultUrl, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid KeyVault url "%s": %w`, conf.URL, err)
+	}
+
+	if vaultUrl.Scheme != "https" || vaultUrl.Hostname() == "" {
+		return nil, fmt.Errorf(`invalid KeyVault url "%s": expected https://name.vault.azure.net/`, conf.URL)
+	}
+
+	ret := &KeyVault{
+		ResourceID: strings.ToLower(conf.ResourceID),
+		Name:       conf.Name,
+		URL:        conf.URL,
+		Static:     true,
+		Labels:     map[string]string{},
+	}
+
+	if ret.Name == "" {
+		ret.Name = strings.SplitN(vaultUrl.Hostname(), ".", 2)[0]
+	}
+
+	if ret.ResourceID == "" {
+		// no resourceID known, use url as unique identifier
+		ret.ResourceID = strings.ToLower(fmt.Sprintf("%s://%s/", vaultUrl.Scheme, vaultUrl.Host))
+	} else if azureResource, err := armclient.ParseResourceId(ret.ResourceID); err == nil {
+		ret.SubscriptionID = azureResource.Subscription
+		ret.ResourceGroup = azureResource.ResourceGroup
+	}
+
+	for name, value := range conf.Labels {
+		switch name {
+		case "tenantID":
+			ret.TenantID = value
+		case "subscriptionID":
+			ret.SubscriptionID = value
+		case "subscriptionName":
+			ret.SubscriptionName = value
+		case "resourceGroup":
+			ret.ResourceGroup = value
+		case "location":
+			ret.Location = value
+		default:
+			found := false
+			for _, labelName := range resourceTagLabels {
+				if labelName == name {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf(
+					`KeyVault "%s": label "%s" is not supported, allowed are %s and resource tag labels (%s)`,
+					ret.Name,
+					name,
+					strings.Join(keyvaultStaticLabels, ", "),
+					strings.Join(resourceTagLabels, ", "),
+				)
+			}
+			ret.Labels[name] = value
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	LeaderElectionBackendNone       = "none"
+	LeaderElectionBackendKubernetes = "kubernetes"
+	LeaderElectionBackendAzBlob     = "azblob"
+	LeaderElectionBackendFile       = "file"
+
+	// namespace of pod (kubernetes service account)
+	leaderElectionNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var (
+	// nil if leader election is disabled (every replica is leader)
+	leaderElection *LeaderElection
+
+	prometheusLeader *prometheus.GaugeVec
+)
+
+// LeaderElection elects one active replica, only the leader collects metrics while followers keep their last metrics
+type LeaderElection struct {
+	backend  string
+	identity string
+	leader   atomic.Bool
+}
+
+func init() {
+	prometheusLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_leader",
+			Help: "Azure KeyVault exporter: leader election role (1 = leader and collecting, 0 = follower)",
+		},
+		[]string{"identity", "backend"},
+	)
+	prometheus.MustRegister(prometheusLeader)
+}
+
+// initLeaderElection starts leader election (if enabled)
+func initLeaderElection() {
+	if Opts.LeaderElection.Backend == "" || Opts.LeaderElection.Backend == LeaderElectionBackendNone {
+		return
+	}
+
+	identity := Opts.LeaderElection.Identity
+	if identity == "" {
+		identity = os.Getenv("POD_NAME")
+	}
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+
+	election := &LeaderElection{
+		backend:  Opts.LeaderElection.Backend,
+		identity: identity,
+	}
+	election.setLeader(false)
+
+	var err error
+	switch election.backend {
+	case LeaderElectionBackendKubernetes:
+		err = election.runKubernetes()
+	case LeaderElectionBackendAzBlob:
+		err = election.runAzBlob()
+	case LeaderElectionBackendFile:
+		err = election.runFile()
+	default:
+		err = fmt.Errorf(`leader election backend "%s" not supported`, election.backend)
+	}
+	if err != nil {
+		logger.Fatalf(`unable to start leader election: %v`, err)
+	}
+
+	logger.Infof(`started leader election using %s as "%s"`, election.backend, election.identity)
+	leaderElection = election
+}
+
+// IsLeader returns true if this replica is the leader (or leader election is disabled)
+func (l *LeaderElection) IsLeader() bool {
+	if l == nil {
+		return true
+	}
+
+	return l.leader.Load()
+}
+
+// setLeader sets role of this replica
+func (l *LeaderElection) setLeader(leader bool) {
+	if l.leader.Swap(leader) != leader {
+		if leader {
+			logger.Infof(`became leader (%s), starting collection with next run`, l.identity)
+		} else {
+			logger.Warnf(`lost leadership (%s), standing by`, l.identity)
+		}
+	}
+
+	value := float64(0)
+	if leader {
+		value = 1
+	}
+	prometheusLeader.WithLabelValues(l.identity, l.backend).Set(value)
+}
+
+// runKubernetes starts leader election using a Kubernetes Lease
+func (l *LeaderElection) runKubernetes() error {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		// outside of cluster, use kubeconfig
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), nil).ClientConfig()
+		if err != nil {
+			return fmt.Errorf(`unable to load kubernetes config: %w`, err)
+		}
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	namespace := Opts.LeaderElection.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		if content, err := os.ReadFile(leaderElectionNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(content))
+		}
+	}
+	if namespace == "" {
+		return fmt.Errorf(`unable to detect namespace, set --leaderelection.namespace`)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      Opts.LeaderElection.Name,
+				Namespace: namespace,
+			},
+			Client: client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: l.identity,
+			},
+		},
+		Name:            Opts.LeaderElection.Name,
+		LeaseDuration:   Opts.LeaderElection.LeaseDuration,
+		RenewDeadline:   Opts.LeaderElection.RenewDeadline,
+		RetryPeriod:     Opts.LeaderElection.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				l.setLeader(true)
+			},
+			OnStoppedLeading: func() {
+				l.setLeader(false)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		// elector returns if leadership is lost, try to acquire it again
+		for {
+			elector.Run(context.Background())
+			time.Sleep(Opts.LeaderElection.RetryPeriod)
+		}
+	}()
+
+	return nil
+}
+
+// runAzBlob starts leader election using a lease on a blob (azblob://storageaccount.blob.core.windows.net/container)
+func (l *LeaderElection) runAzBlob() error {
+	parsedUrl, err := url.Parse(Opts.LeaderElection.Path)
+	if err != nil || parsedUrl.Scheme != "azblob" {
+		return fmt.Errorf(`azblob path needs to be specified as azblob://storageaccount.blob.core.windows.net/container, got: %v`, Opts.LeaderElection.Path)
+	}
+
+	container := strings.Trim(parsedUrl.Path, "/")
+	blobName := Opts.LeaderElection.Name + ".lock"
+
+	client, err := newAzBlobClient(parsedUrl)
+	if err != nil {
+		return err
+	}
+
+	leaseID := uuid.NewString()
+	leaseClient, err := lease.NewBlobClient(
+		client.ServiceClient().NewContainerClient(container).NewBlobClient(blobName),
+		&lease.BlobClientOptions{LeaseID: &leaseID},
+	)
+	if err != nil {
+		return err
+	}
+
+	// blob leases have to be between 15 and 60 seconds
+	leaseDuration := int32(Opts.LeaderElection.LeaseDuration.Seconds())
+	leaseDuration = min(max(leaseDuration, 15), 60)
+
+	go func() {
+		lastRenew := time.Time{}
+		for {
+			func() {
+				ctx, cancel := context.WithTimeout(context.Background(), Opts.LeaderElection.RenewDeadline)
+				defer cancel()
+
+				if l.IsLeader() {
+					if _, err := leaseClient.RenewLease(ctx, nil); err == nil {
+						lastRenew = time.Now()
+					} else if time.Since(lastRenew) > Opts.LeaderElection.RenewDeadline {
+						logger.Warnf(`unable to renew blob lease: %v`, err)
+						l.setLeader(false)
+					}
+					return
+				}
+
+				// create lock blob if not exists (fails if blob exists or is leased)
+				etagAny := azcore.ETagAny
+				_, _ = client.UploadBuffer(ctx, container, blobName, []byte{}, &azblob.UploadBufferOptions{
+					AccessConditions: &blob.AccessConditions{
+						ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+					},
+				})
+
+				if _, err := leaseClient.AcquireLease(ctx, leaseDuration, nil); err == nil {
+					lastRenew = time.Now()
+					l.setLeader(true)
+				}
+			}()
+
+			time.Sleep(Opts.LeaderElection.RetryPeriod)
+		}
+	}()
+
+	return nil
+}
+
+// runFile starts leader election using an exclusive file lock (all replicas on the same host or shared filesystem)
+func (l *LeaderElection) runFile() error {
+	path := Opts.LeaderElection.Path
+	if path == "" {
+		path = os.TempDir()
+	}
+	path = filepath.Join(strings.TrimPrefix(path, "file://"), Opts.LeaderElection.Name+".lock")
+
+	file, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		// lock is held until process exits
+		for {
+			if err := lockFile(file); err == nil {
+				if err := file.Truncate(0); err == nil {
+					_, _ = file.WriteAt([]byte(l.identity), 0)
+				}
+				l.setLeader(true)
+				return
+			}
+
+			time.Sleep(Opts.LeaderElection.RetryPeriod)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/prometheus/collector"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	OtlpMetricsProtocolNone = "none"
+	OtlpMetricsProtocolGrpc = "grpc"
+	OtlpMetricsProtocolHttp = "http"
+
+	// only metric families of the exporter are pushed (no go runtime or process metrics)
+	otlpMetricsPrefix = "azurerm_keyvault_"
+
+	otlpMetricsSuffixInfo   = "_info"
+	otlpMetricsSuffixStatus = "_status"
+)
+
+var (
+	// OTLP metrics pusher (nil if disabled)
+	otlpMetrics *OtlpMetricsPusher
+
+	prometheusOtlpPush *prometheus.CounterVec
+)
+
+type (
+	// OtlpMetricsPusher pushes the KeyVault metric families via OTLP after each collection run and in a fixed interval
+	OtlpMetricsPusher struct {
+		exporter  otlpMetricsExporter
+		resource  *resource.Resource
+		startTime time.Time
+		trigger   chan struct{}
+	}
+
+	// otlpMetricsExporter is implemented by the OTLP grpc and http metric exporters
+	otlpMetricsExporter interface {
+		Export(ctx context.Context, rm *metricdata.ResourceMetrics) error
+	}
+
+	// otlpMetricsInfoIndex indexes info metrics by the labels which are shared with the status metric (eg. resourceID and keyID)
+	otlpMetricsInfoIndex struct {
+		joinLabels []string
+		rows       map[string]*dto.Metric
+	}
+)
+
+func init() {
+	prometheusOtlpPush = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_otlp_push_total",
+			Help: "Azure KeyVault exporter: OTLP metric pushes by result",
+		},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(prometheusOtlpPush)
+}
+
+// initOtlpMetrics starts OTLP metrics push (if enabled)
+func initOtlpMetrics() {
+	if Opts.OtlpMetrics.Protocol == "" || Opts.OtlpMetrics.Protocol == OtlpMetricsProtocolNone {
+		return
+	}
+
+	ctx := context.Background()
+
+	exporter, err := newOtlpMetricsExporter(ctx)
+	if err != nil {
+		logger.Fatalf(`unable to create OTLP metrics exporter: %v`, err)
+	}
+
+	metricsResource, err := newOtelResource(ctx)
+	if err != nil {
+		logger.Fatalf(`unable to create OTLP metrics resource: %v`, err)
+	}
+
+	otlpMetrics = &OtlpMetricsPusher{
+		exporter:  exporter,
+		resource:  metricsResource,
+		startTime: time.Now(),
+		trigger:   make(chan struct{}, 1),
+	}
+	go otlpMetrics.run()
+
+	logger.Infof(`enabled OTLP metrics push using %s (interval %v)`, Opts.OtlpMetrics.Protocol, Opts.OtlpMetrics.Interval)
+}
+
+// newOtlpMetricsExporter creates OTLP metric exporter, exporter is also configured by OTEL_EXPORTER_OTLP_* env vars
+func newOtlpMetricsExporter(ctx context.Context) (otlpMetricsExporter, error) {
+	headers := map[string]string{}
+	for _, header := range Opts.OtlpMetrics.Headers {
+		name, value, found := strings.Cut(header, "=")
+		if !found {
+			return nil, fmt.Errorf(`invalid OTLP header, expected format key=value`)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	tlsConfig, err := newOtlpMetricsTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch Opts.OtlpMetrics.Protocol {
+	case OtlpMetricsProtocolGrpc:
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithTimeout(Opts.OtlpMetrics.Timeout),
+		}
+		if Opts.OtlpMetrics.Endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(Opts.OtlpMetrics.Endpoint))
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(headers))
+		}
+		if Opts.OtlpMetrics.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		} else if tlsConfig != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case OtlpMetricsProtocolHttp:
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithTimeout(Opts.OtlpMetrics.Timeout),
+		}
+		if Opts.OtlpMetrics.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(Opts.OtlpMetrics.Endpoint))
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(headers))
+		}
+		if Opts.OtlpMetrics.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		} else if tlsConfig != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsConfig))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+
+	return nil, fmt.Errorf(`OTLP metrics protocol "%s" not supported`, Opts.OtlpMetrics.Protocol)
+}
+
+// newOtlpMetricsTlsConfig returns TLS config if CA, client certificate or skip-verify is set (otherwise nil for the exporter default)
+func newOtlpMetricsTlsConfig() (*tls.Config, error) {
+	if Opts.OtlpMetrics.TlsCa == "" && Opts.OtlpMetrics.TlsCert == "" && !Opts.OtlpMetrics.TlsSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: Opts.OtlpMetrics.TlsSkipVerify, // #nosec G402 explicitly enabled by user
+	}
+
+	if Opts.OtlpMetrics.TlsCa != "" {
+		caPem, err := os.ReadFile(filepath.Clean(Opts.OtlpMetrics.TlsCa))
+		if err != nil {
+			return nil, fmt.Errorf(`unable to read OTLP CA certificate: %w`, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf(`no certificates found in OTLP CA certificate "%s"`, Opts.OtlpMetrics.TlsCa)
+		}
+	}
+
+	if Opts.OtlpMetrics.TlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(Opts.OtlpMetrics.TlsCert, Opts.OtlpMetrics.TlsKey)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to load OTLP client certificate: %w`, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Trigger requests a push of the metrics after a collection run (nil safe, non blocking)
+func (p *OtlpMetricsPusher) Trigger() {
+	if p == nil {
+		return
+	}
+
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+		// push already pending
+	}
+}
+
+// run pushes metrics when triggered or in push interval
+func (p *OtlpMetricsPusher) run() {
+	var ticker <-chan time.Time
+	if Opts.OtlpMetrics.Interval > 0 {
+		ticker = time.NewTicker(Opts.OtlpMetrics.Interval).C
+	}
+
+	for {
+		select {
+		case <-p.trigger:
+		case <-ticker:
+		}
+
+		if !leaderElection.IsLeader() {
+			// follower, metrics would be duplicated
+			continue
+		}
+
+		if err := p.push(); err != nil {
+			prometheusOtlpPush.WithLabelValues(InstrumentationResultFailed).Inc()
+			logger.Errorf(`OTLP metrics push failed: %v`, err)
+		} else {
+			prometheusOtlpPush.WithLabelValues(InstrumentationResultSuccess).Inc()
+		}
+	}
+}
+
+// push gathers the KeyVault metric families and exports them
+func (p *OtlpMetricsPusher) push() error {
+	// wait until running collector finished setting its metrics
+	collector.Lock().RLock()
+	families, err := prometheus.DefaultGatherer.Gather()
+	collector.Lock().RUnlock()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Opts.OtlpMetrics.Timeout)
+	defer cancel()
+
+	return p.exporter.Export(ctx, &metricdata.ResourceMetrics{
+		Resource: p.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: tracingInstrumentationName, Version: gitTag},
+			Metrics: otlpMetricsConvert(families, p.startTime, time.Now()),
+		}},
+	})
+}
+
+// otlpMetricsConvert converts Prometheus metric families to OTLP metrics, labels are used as attributes (empty labels are
+// omitted) and the attributes of info metrics (*_info) are also added to the matching status metric (*_status)
+func otlpMetricsConvert(families []*dto.MetricFamily, startTime, now time.Time) []metricdata.Metrics {
+	familyMap := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		familyMap[family.GetName()] = family
+	}
+
+	ret := []metricdata.Metrics{}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), otlpMetricsPrefix) || len(family.GetMetric()) == 0 {
+			continue
+		}
+
+		var info *otlpMetricsInfoIndex
+		if strings.HasSuffix(family.GetName(), otlpMetricsSuffixStatus) {
+			if infoFamily, exists := familyMap[strings.TrimSuffix(family.GetName(), otlpMetricsSuffixStatus)+otlpMetricsSuffixInfo]; exists {
+				info = newOtlpMetricsInfoIndex(infoFamily, family)
+			}
+		}
+
+		metric := metricdata.Metrics{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			data := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			for _, row := range family.GetMetric() {
+				data.DataPoints = append(data.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: otlpMetricsAttributes(row, info),
+					StartTime:  startTime,
+					Time:       now,
+					Value:      row.GetCounter().GetValue(),
+				})
+			}
+			metric.Data = data
+		case dto.MetricType_HISTOGRAM:
+			data := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, row := range family.GetMetric() {
+				data.DataPoints = append(data.DataPoints, otlpMetricsHistogram(row, info, startTime, now))
+			}
+			metric.Data = data
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			data := metricdata.Gauge[float64]{}
+			for _, row := range family.GetMetric() {
+				value := row.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = row.GetUntyped().GetValue()
+				}
+
+				data.DataPoints = append(data.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: otlpMetricsAttributes(row, info),
+					Time:       now,
+					Value:      value,
+				})
+			}
+			metric.Data = data
+		default:
+			// summaries are not used by the exporter
+			continue
+		}
+
+		ret = append(ret, metric)
+	}
+
+	return ret
+}
+
+// otlpMetricsHistogram converts Prometheus histogram (cumulative buckets) to OTLP histogram data point (bucket counts)
+func otlpMetricsHistogram(row *dto.Metric, info *otlpMetricsInfoIndex, startTime, now time.Time) metricdata.HistogramDataPoint[float64] {
+	histogram := row.GetHistogram()
+
+	dataPoint := metricdata.HistogramDataPoint[float64]{
+		Attributes: otlpMetricsAttributes(row, info),
+		StartTime:  startTime,
+		Time:       now,
+		Count:      histogram.GetSampleCount(),
+		Sum:        histogram.GetSampleSum(),
+	}
+
+	cumulativeCount := uint64(0)
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+
+		dataPoint.Bounds = append(dataPoint.Bounds, bucket.GetUpperBound())
+		dataPoint.BucketCounts = append(dataPoint.BucketCounts, bucket.GetCumulativeCount()-cumulativeCount)
+		cumulativeCount = bucket.GetCumulativeCount()
+	}
+	// overflow bucket (+Inf)
+	dataPoint.BucketCounts = append(dataPoint.BucketCounts, histogram.GetSampleCount()-cumulativeCount)
+
+	return dataPoint
+}
+
+// otlpMetricsAttributes returns labels of metric as attributes (incl. attributes of matching info metric)
+func otlpMetricsAttributes(row *dto.Metric, info *otlpMetricsInfoIndex) attribute.Set {
+	attributes := []attribute.KeyValue{}
+	labels := map[string]bool{}
+	for _, label := range row.GetLabel() {
+		labels[label.GetName()] = true
+		if label.GetValue() != "" {
+			attributes = append(attributes, attribute.String(label.GetName(), label.GetValue()))
+		}
+	}
+
+	if infoRow := info.lookup(row); infoRow != nil {
+		for _, label := range infoRow.GetLabel() {
+			if !labels[label.GetName()] && label.GetValue() != "" {
+				attributes = append(attributes, attribute.String(label.GetName(), label.GetValue()))
+			}
+		}
+	}
+
+	return attribute.NewSet(attributes...)
+}
+
+// newOtlpMetricsInfoIndex creates index of info family for status family
+func newOtlpMetricsInfoIndex(infoFamily, statusFamily *dto.MetricFamily) *otlpMetricsInfoIndex {
+	if len(infoFamily.GetMetric()) == 0 || len(statusFamily.GetMetric()) == 0 {
+		return nil
+	}
+
+	// label names are the same for all metrics of a family
+	statusLabels := map[string]bool{}
+	for _, label := range statusFamily.GetMetric()[0].GetLabel() {
+		statusLabels[label.GetName()] = true
+	}
+
+	index := &otlpMetricsInfoIndex{rows: map[string]*dto.Metric{}}
+	for _, label := range infoFamily.GetMetric()[0].GetLabel() {
+		if statusLabels[label.GetName()] {
+			index.joinLabels = append(index.joinLabels, label.GetName())
+		}
+	}
+	if len(index.joinLabels) == 0 {
+		return nil
+	}
+
+	for _, row := range infoFamily.GetMetric() {
+		index.rows[index.key(row)] = row
+	}
+
+	return index
+}
+
+// key returns join key of metric
+func (i *otlpMetricsInfoIndex) key(row *dto.Metric) string {
+	values := make([]string, len(i.joinLabels))
+	for _, label := range row.GetLabel() {
+		for n, name := range i.joinLabels {
+			if label.GetName() == name {
+				values[n] = label.GetValue()
+			}
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// lookup returns info metric matching the status metric (nil safe)
+func (i *otlpMetricsInfoIndex) lookup(row *dto.Metric) *dto.Metric {
+	if i == nil {
+		return nil
+	}
+
+	return i.rows[i.key(row)]
+}
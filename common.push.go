@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/prometheus/collector"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	PushModeNone        = "none"
+	PushModePushgateway = "pushgateway"
+	PushModeRemoteWrite = "remotewrite"
+
+	// max length of error response body in error messages
+	pushErrorBodyLength = 512
+)
+
+var (
+	prometheusPush *prometheus.CounterVec
+)
+
+type (
+	// MetricsPusher pushes the gathered registry to a Pushgateway or a Prometheus remote-write endpoint after each collection run
+	MetricsPusher struct {
+		labels map[string]string
+		client *http.Client
+	}
+
+	// pushSeries is a sample of a remote-write time series
+	pushSeries struct {
+		labels map[string]string
+		value  float64
+	}
+
+	// pushPermanentError is returned for requests which should not be retried (eg. bad request)
+	pushPermanentError struct {
+		err error
+	}
+
+	// pushAuthTransport adds bearer token to requests
+	pushAuthTransport struct {
+		next http.RoundTripper
+	}
+)
+
+func init() {
+	prometheusPush = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_push_total",
+			Help: "Azure KeyVault exporter: metric pushes (pushgateway, remotewrite) by result",
+		},
+		[]string{"mode", "result"},
+	)
+	prometheus.MustRegister(prometheusPush)
+}
+
+// initMetricsPush starts push of metrics (if enabled)
+func initMetricsPush() {
+	if Opts.Push.Mode == "" || Opts.Push.Mode == PushModeNone {
+		if Opts.Push.Once {
+			logger.Fatal(`--push.once needs a push mode (--push.mode)`)
+		}
+		return
+	}
+
+	if Opts.Push.Url == "" {
+		logger.Fatal(`--push.url is required for push mode`)
+	}
+
+	if Opts.Push.Once && Opts.LeaderElection.Backend != LeaderElectionBackendNone {
+		logger.Fatal(`--push.once can not be used with leader election`)
+	}
+
+	pusher := &MetricsPusher{
+		labels: map[string]string{},
+		client: &http.Client{
+			Timeout:   Opts.Push.Timeout,
+			Transport: &pushAuthTransport{next: http.DefaultTransport},
+		},
+	}
+
+	for _, label := range Opts.Push.Labels {
+		name, value, found := strings.Cut(label, "=")
+		if !found {
+			logger.Fatalf(`invalid push label "%s", expected format key=value`, label)
+		}
+		pusher.labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	startMetricsSink(Opts.Push.Once, pusher.output)
+
+	logger.Infof(`enabled %s push to %s`, Opts.Push.Mode, Opts.Push.Url)
+}
+
+// output pushes metrics (with retries) and counts the result
+func (p *MetricsPusher) output() error {
+	err := p.pushWithRetry()
+	if err != nil {
+		prometheusPush.WithLabelValues(Opts.Push.Mode, InstrumentationResultFailed).Inc()
+		logger.Errorf(`%s push failed: %v`, Opts.Push.Mode, err)
+	} else {
+		prometheusPush.WithLabelValues(Opts.Push.Mode, InstrumentationResultSuccess).Inc()
+		logger.Infof(`pushed metrics to %s`, Opts.Push.Mode)
+	}
+	return err
+}
+
+// pushWithRetry pushes metrics, failed pushes are retried with exponential backoff
+func (p *MetricsPusher) pushWithRetry() (err error) {
+	retryWait := Opts.Push.RetryWait
+	for attempt := 0; ; attempt++ {
+		err = p.push()
+
+		var permanentErr *pushPermanentError
+		if err == nil || errors.As(err, &permanentErr) || attempt >= Opts.Push.Retries {
+			return err
+		}
+
+		logger.Warnf(`%s push failed (attempt %d of %d), retrying in %s: %v`, Opts.Push.Mode, attempt+1, Opts.Push.Retries+1, retryWait, err)
+		time.Sleep(retryWait)
+		retryWait *= 2
+	}
+}
+
+// push pushes the gathered registry
+func (p *MetricsPusher) push() error {
+	// wait until running collector finished setting its metrics
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		collector.Lock().RLock()
+		defer collector.Lock().RUnlock()
+		return prometheus.DefaultGatherer.Gather()
+	})
+
+	switch Opts.Push.Mode {
+	case PushModePushgateway:
+		pusher := push.New(Opts.Push.Url, Opts.Push.Job).Gatherer(gatherer).Client(p.client)
+		for name, value := range p.labels {
+			pusher = pusher.Grouping(name, value)
+		}
+		if Opts.Push.Username != "" {
+			pusher = pusher.BasicAuth(Opts.Push.Username, Opts.Push.Password)
+		}
+		// replaces all metrics of the group, metrics of removed KeyVaults are removed too
+		return pusher.Push()
+	case PushModeRemoteWrite:
+		families, err := gatherer.Gather()
+		if err != nil {
+			return err
+		}
+		return p.remoteWrite(families)
+	}
+
+	return fmt.Errorf(`push mode "%s" not supported`, Opts.Push.Mode)
+}
+
+// remoteWrite sends metric families to Prometheus remote-write endpoint (protobuf, snappy compressed)
+func (p *MetricsPusher) remoteWrite(families []*dto.MetricFamily) error {
+	labels := map[string]string{"job": Opts.Push.Job}
+	for name, value := range p.labels {
+		labels[name] = value
+	}
+
+	body := s2.EncodeSnappy(nil, pushRemoteWriteRequest(pushRemoteWriteSeries(families, labels), time.Now().UnixMilli()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), Opts.Push.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, Opts.Push.Url, bytes.NewReader(body))
+	if err != nil {
+		return &pushPermanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("User-Agent", "azure-keyvault-exporter/"+gitTag)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if Opts.Push.Username != "" {
+		req.SetBasicAuth(Opts.Push.Username, Opts.Push.Password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, pushErrorBodyLength))
+	err = fmt.Errorf(`unexpected status code %d from remote-write endpoint: %s`, resp.StatusCode, strings.TrimSpace(string(responseBody)))
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		// rejected data, retry would fail again
+		return &pushPermanentError{err: err}
+	}
+	return err
+}
+
+// pushRemoteWriteSeries converts metric families to remote-write series (histograms and summaries are split into
+// _bucket/quantile, _sum and _count series as in the Prometheus text format), labels are added to all series
+func pushRemoteWriteSeries(families []*dto.MetricFamily, labels map[string]string) []pushSeries {
+	ret := []pushSeries{}
+
+	for _, family := range families {
+		for _, row := range family.GetMetric() {
+			add := func(suffix string, value float64, extraLabels ...string) {
+				seriesLabels := map[string]string{}
+				for name, value := range labels {
+					seriesLabels[name] = value
+				}
+				for _, label := range row.GetLabel() {
+					seriesLabels[label.GetName()] = label.GetValue()
+				}
+				for i := 0; i+1 < len(extraLabels); i += 2 {
+					seriesLabels[extraLabels[i]] = extraLabels[i+1]
+				}
+				seriesLabels["__name__"] = family.GetName() + suffix
+
+				ret = append(ret, pushSeries{labels: seriesLabels, value: value})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", row.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", row.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", row.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := row.GetHistogram()
+				hasInf := false
+				for _, bucket := range histogram.GetBucket() {
+					hasInf = hasInf || math.IsInf(bucket.GetUpperBound(), +1)
+					add("_bucket", float64(bucket.GetCumulativeCount()), "le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64))
+				}
+				if !hasInf {
+					add("_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+				}
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := row.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add("", quantile.GetValue(), "quantile", strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64))
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			}
+		}
+	}
+
+	return ret
+}
+
+// pushRemoteWriteRequest encodes remote-write WriteRequest (protobuf) with one sample per series
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func pushRemoteWriteRequest(seriesList []pushSeries, timestamp int64) []byte {
+	var request []byte
+
+	for _, series := range seriesList {
+		// labels have to be sorted by name
+		names := make([]string, 0, len(series.labels))
+		for name := range series.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var timeSeries []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, series.labels[name])
+
+			timeSeries = protowire.AppendTag(timeSeries, 1, protowire.BytesType)
+			timeSeries = protowire.AppendBytes(timeSeries, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(series.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp)) // #nosec G115 unix timestamp is positive
+
+		timeSeries = protowire.AppendTag(timeSeries, 2, protowire.BytesType)
+		timeSeries = protowire.AppendBytes(timeSeries, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, timeSeries)
+	}
+
+	return request
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *pushAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := Opts.Push.BearerToken
+	if Opts.Push.BearerTokenFile != "" {
+		content, err := os.ReadFile(filepath.Clean(Opts.Push.BearerTokenFile))
+		if err != nil {
+			return nil, fmt.Errorf(`unable to read bearer token file: %w`, err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+func (e *pushPermanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pushPermanentError) Unwrap() error {
+	return e.err
+}
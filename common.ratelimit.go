@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	RateLimitScopeVault        = "vault"
+	RateLimitScopeSubscription = "subscription"
+
+	// rate is reduced on throttling down to this fraction of the configured rate
+	rateLimitMinFactor = 0.1
+	// rate is increased by this factor per successful request until the configured rate is reached again
+	rateLimitRecoveryFactor = 1.05
+)
+
+var (
+	rateLimitSubscriptionRegExp = regexp.MustCompile(`(?i)/subscriptions/([^/]+)`)
+
+	azureRateLimiterVault        *AzureRateLimiter
+	azureRateLimiterSubscription *AzureRateLimiter
+
+	prometheusRateLimitThrottled *prometheus.CounterVec
+	prometheusRateLimitWait      *prometheus.CounterVec
+)
+
+type (
+	// AzureRateLimiter is a token bucket rate limiter with one bucket per key (eg. KeyVault or subscription)
+	AzureRateLimiter struct {
+		scope string
+		rate  rate.Limit
+		burst int
+
+		buckets map[string]*azureRateLimitBucket
+		lock    sync.Mutex
+	}
+
+	azureRateLimitBucket struct {
+		limiter     *rate.Limiter
+		pausedUntil time.Time
+		lock        sync.Mutex
+	}
+
+	// azureRateLimitPolicy is an azcore pipeline policy which waits for the rate limiter and
+	// pauses the bucket when Azure responds with Retry-After
+	azureRateLimitPolicy struct {
+		limiter *AzureRateLimiter
+		keyFunc func(req *http.Request) string
+	}
+)
+
+func init() {
+	prometheusRateLimitThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_ratelimit_throttled_total",
+			Help: "Azure KeyVault exporter: requests throttled by Azure (HTTP 429 or Retry-After)",
+		},
+		[]string{"scope"},
+	)
+	prometheus.MustRegister(prometheusRateLimitThrottled)
+
+	prometheusRateLimitWait = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_ratelimit_wait_seconds_total",
+			Help: "Azure KeyVault exporter: time spent waiting for rate limiter",
+		},
+		[]string{"scope"},
+	)
+	prometheus.MustRegister(prometheusRateLimitWait)
+}
+
+// initRateLimiter creates rate limiters for KeyVault (data-plane) and subscription (ARM) requests (opt-in, nil if disabled)
+func initRateLimiter() {
+	if Opts.RateLimit.Vault > 0 {
+		azureRateLimiterVault = NewAzureRateLimiter(RateLimitScopeVault, Opts.RateLimit.Vault, Opts.RateLimit.VaultBurst)
+	}
+	if Opts.RateLimit.Subscription > 0 {
+		azureRateLimiterSubscription = NewAzureRateLimiter(RateLimitScopeSubscription, Opts.RateLimit.Subscription, Opts.RateLimit.SubscriptionBurst)
+	}
+}
+
+// NewAzureRateLimiter creates new rate limiter, requestsPerSecond <= 0 disables limiting (Retry-After is still honored)
+func NewAzureRateLimiter(scope string, requestsPerSecond float64, burst int) *AzureRateLimiter {
+	limiter := &AzureRateLimiter{
+		scope:   scope,
+		rate:    rate.Inf,
+		burst:   burst,
+		buckets: map[string]*azureRateLimitBucket{},
+	}
+
+	if requestsPerSecond > 0 {
+		limiter.rate = rate.Limit(requestsPerSecond)
+	}
+
+	if limiter.burst <= 0 {
+		limiter.burst = 1
+	}
+
+	return limiter
+}
+
+// bucket returns bucket for key (created if not exists)
+func (l *AzureRateLimiter) bucket(key string) *azureRateLimitBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, exists := l.buckets[key]; !exists {
+		l.buckets[key] = &azureRateLimitBucket{
+			limiter: rate.NewLimiter(l.rate, l.burst),
+		}
+	}
+
+	return l.buckets[key]
+}
+
+// Wait blocks until a request for key is allowed
+func (l *AzureRateLimiter) Wait(ctx context.Context, key string) error {
+	bucket := l.bucket(key)
+
+	startTime := time.Now()
+	defer func() {
+		if waitTime := time.Since(startTime); waitTime >= time.Millisecond {
+			prometheusRateLimitWait.WithLabelValues(l.scope).Add(waitTime.Seconds())
+		}
+	}()
+
+	// paused by Retry-After
+	bucket.lock.Lock()
+	pauseDuration := time.Until(bucket.pausedUntil)
+	bucket.lock.Unlock()
+	if pauseDuration > 0 {
+		timer := time.NewTimer(pauseDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return bucket.limiter.Wait(ctx)
+}
+
+// Throttled pauses bucket of key for retryAfter and reduces the request rate (adaptive backoff)
+func (l *AzureRateLimiter) Throttled(key string, retryAfter time.Duration) {
+	prometheusRateLimitThrottled.WithLabelValues(l.scope).Inc()
+
+	bucket := l.bucket(key)
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	if pausedUntil := time.Now().Add(retryAfter); pausedUntil.After(bucket.pausedUntil) {
+		bucket.pausedUntil = pausedUntil
+	}
+
+	if l.rate != rate.Inf {
+		limit := bucket.limiter.Limit() / 2
+		if minLimit := l.rate * rateLimitMinFactor; limit < minLimit {
+			limit = minLimit
+		}
+		bucket.limiter.SetLimit(limit)
+	}
+}
+
+// Success increases the reduced request rate of key until the configured rate is reached again
+func (l *AzureRateLimiter) Success(key string) {
+	if l.rate == rate.Inf {
+		return
+	}
+
+	bucket := l.bucket(key)
+	if limit := bucket.limiter.Limit(); limit < l.rate {
+		limit *= rateLimitRecoveryFactor
+		if limit > l.rate {
+			limit = l.rate
+		}
+		bucket.limiter.SetLimit(limit)
+	}
+}
+
+// Do implements azcore policy
+func (p *azureRateLimitPolicy) Do(req *policy.Request) (*http.Response, error) {
+	key := p.keyFunc(req.Raw())
+	if key == "" {
+		return req.Next()
+	}
+
+	if err := p.limiter.Wait(req.Raw().Context(), key); err != nil {
+		return nil, err
+	}
+
+	resp, err := req.Next()
+	if resp != nil {
+		if retryAfter, throttled := rateLimitRetryAfter(resp); throttled {
+			p.limiter.Throttled(key, retryAfter)
+		} else if err == nil {
+			p.limiter.Success(key)
+		}
+	}
+
+	return resp, err
+}
+
+// NewAzCoreClientOptions returns client options for KeyVault data-plane clients (rate limited by KeyVault, requests are counted and traced)
+func (t *AzureTarget) NewAzCoreClientOptions() azcore.ClientOptions {
+	opts := t.Client.NewAzCoreClientOptions()
+	if azureRateLimiterVault != nil {
+		opts.PerRetryPolicies = append(opts.PerRetryPolicies, &azureRateLimitPolicy{
+			limiter: azureRateLimiterVault,
+			keyFunc: func(req *http.Request) string {
+				return strings.ToLower(req.URL.Host)
+			},
+		})
+	}
+	opts.PerRetryPolicies = append(opts.PerRetryPolicies, &azureApiMetricsPolicy{api: InstrumentationApiKeyVault})
+	if azureTracingProvider != nil {
+		opts.TracingProvider = *azureTracingProvider
+	}
+	return *opts
+}
+
+// NewArmClientOptions returns client options for ARM clients (rate limited by subscription, tenant level requests like
+// subscription listing and ResourceGraph queries by target, requests are counted and traced)
+func (t *AzureTarget) NewArmClientOptions() *arm.ClientOptions {
+	opts := t.Client.NewArmClientOptions()
+	if azureRateLimiterSubscription != nil {
+		opts.PerRetryPolicies = append(opts.PerRetryPolicies, &azureRateLimitPolicy{
+			limiter: azureRateLimiterSubscription,
+			keyFunc: func(req *http.Request) string {
+				if match := rateLimitSubscriptionRegExp.FindStringSubmatch(req.URL.Path); match != nil {
+					return strings.ToLower(match[1])
+				}
+				return "target:" + t.Name
+			},
+		})
+	}
+	opts.PerRetryPolicies = append(opts.PerRetryPolicies, &azureApiMetricsPolicy{api: InstrumentationApiArm})
+	if azureTracingProvider != nil {
+		opts.TracingProvider = *azureTracingProvider
+	}
+	return opts
+}
+
+// rateLimitRetryAfter returns Retry-After duration if response was throttled
+func rateLimitRetryAfter(resp *http.Response) (time.Duration, bool) {
+	retryAfter := time.Duration(0)
+
+	for _, header := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if val := resp.Header.Get(header); val != "" {
+			if ms, err := strconv.Atoi(val); err == nil {
+				retryAfter = time.Duration(ms) * time.Millisecond
+				break
+			}
+		}
+	}
+
+	if retryAfter == 0 {
+		if val := resp.Header.Get("Retry-After"); val != "" {
+			if seconds, err := strconv.Atoi(val); err == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			} else if date, err := http.ParseTime(val); err == nil {
+				retryAfter = time.Until(date)
+			}
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfter, true
+	case resp.StatusCode == http.StatusServiceUnavailable && retryAfter > 0:
+		return retryAfter, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// StatefulSet pod names end with the ordinal (eg. azure-keyvault-exporter-2)
+	shardingOrdinalRegExp = regexp.MustCompile(`-(\d+)$`)
+
+	keyvaultSharding *KeyVaultSharding
+
+	prometheusShardInfo   *prometheus.GaugeVec
+	prometheusShardVaults *prometheus.GaugeVec
+)
+
+// KeyVaultSharding assigns KeyVaults to shards (replicas) using rendezvous hashing of the lowercased resource ID,
+// changing the shard count only moves the KeyVaults of added/removed shards
+type KeyVaultSharding struct {
+	Index int
+	Count int
+}
+
+func init() {
+	prometheusShardInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_shard_info",
+			Help: "Azure KeyVault exporter: shard of this replica",
+		},
+		[]string{"shard", "shardCount"},
+	)
+	prometheus.MustRegister(prometheusShardInfo)
+
+	prometheusShardVaults = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_shard_vaults",
+			Help: "Azure KeyVault exporter: KeyVaults discovered (all shards) and assigned to this shard",
+		},
+		[]string{"target", "shard", "type"},
+	)
+	prometheus.MustRegister(prometheusShardVaults)
+}
+
+// initSharding sets shard index and count from args (index is taken from StatefulSet pod ordinal if not set)
+func initSharding() {
+	sharding, err := NewKeyVaultSharding(Opts.Sharding.Index, Opts.Sharding.Count)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	keyvaultSharding = sharding
+
+	if sharding.Count > 1 {
+		logger.Infof("sharding enabled, collecting shard %v of %v", sharding.Index, sharding.Count)
+	}
+
+	prometheusShardInfo.WithLabelValues(strconv.Itoa(sharding.Index), strconv.Itoa(sharding.Count)).Set(1)
+}
+
+// NewKeyVaultSharding creates sharding, index < 0 is detected from hostname (StatefulSet pod ordinal)
+func NewKeyVaultSharding(index, count int) (*KeyVaultSharding, error) {
+	if count <= 1 {
+		return &KeyVaultSharding{Index: 0, Count: 1}, nil
+	}
+
+	if index < 0 {
+		hostname := os.Getenv("POD_NAME")
+		if hostname == "" {
+			var err error
+			if hostname, err = os.Hostname(); err != nil {
+				return nil, fmt.Errorf(`unable to detect shard index: %w`, err)
+			}
+		}
+
+		match := shardingOrdinalRegExp.FindStringSubmatch(hostname)
+		if match == nil {
+			return nil, fmt.Errorf(`unable to detect shard index from hostname "%s", set --sharding.index`, hostname)
+		}
+
+		var err error
+		if index, err = strconv.Atoi(match[1]); err != nil {
+			return nil, fmt.Errorf(`unable to detect shard index from hostname "%s", set --sharding.index: %w`, hostname, err)
+		}
+	}
+
+	if index >= count {
+		return nil, fmt.Errorf(`shard index %v is out of range (shard count %v)`, index, count)
+	}
+
+	return &KeyVaultSharding{Index: index, Count: count}, nil
+}
+
+// Shard returns shard of KeyVault resource ID
+func (s *KeyVaultSharding) Shard(resourceID string) int {
+	if s.Count <= 1 {
+		return 0
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.ToLower(resourceID))) // #nosec G104 hash writes never fail
+	resourceHash := hash.Sum64()
+
+	shard := 0
+	maxScore := uint64(0)
+	for i := 0; i < s.Count; i++ {
+		if score := shardingMix(resourceHash ^ (uint64(i+1) * 0x9e3779b97f4a7c15)); i == 0 || score > maxScore {
+			shard = i
+			maxScore = score
+		}
+	}
+
+	return shard
+}
+
+// shardingMix is the splitmix64 finalizer, used to get evenly distributed scores per shard
+func shardingMix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Filter returns KeyVaults assigned to this shard
+func (s *KeyVaultSharding) Filter(target *AzureTarget, vaults []*KeyVault) []*KeyVault {
+	if s == nil {
+		return vaults
+	}
+
+	ret := vaults
+	if s.Count > 1 {
+		ret = []*KeyVault{}
+		for _, vault := range vaults {
+			if s.Shard(vault.ResourceID) == s.Index {
+				ret = append(ret, vault)
+			}
+		}
+	}
+
+	shard := strconv.Itoa(s.Index)
+	prometheusShardVaults.WithLabelValues(target.Name, shard, "discovered").Set(float64(len(vaults)))
+	prometheusShardVaults.WithLabelValues(target.Name, shard, "assigned").Set(float64(len(ret)))
+
+	return ret
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	testShardingVaultA = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-a/providers/Microsoft.KeyVault/vaults/kv-a"
+	testShardingVaultB = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-a/providers/Microsoft.KeyVault/vaults/kv-b"
+	testShardingVaultC = "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/rg-b/providers/Microsoft.KeyVault/vaults/kv-c"
+)
+
+func TestKeyVaultShardingShard(t *testing.T) {
+	tests := []struct {
+		resourceID string
+		count      int
+		shard      int
+	}{
+		{resourceID: testShardingVaultA, count: 1, shard: 0},
+		{resourceID: testShardingVaultA, count: 2, shard: 1},
+		{resourceID: testShardingVaultA, count: 3, shard: 2},
+		{resourceID: testShardingVaultA, count: 4, shard: 2},
+		{resourceID: testShardingVaultB, count: 2, shard: 1},
+		{resourceID: testShardingVaultB, count: 3, shard: 1},
+		{resourceID: testShardingVaultB, count: 4, shard: 3},
+		{resourceID: testShardingVaultC, count: 2, shard: 0},
+		{resourceID: testShardingVaultC, count: 3, shard: 0},
+		{resourceID: testShardingVaultC, count: 4, shard: 0},
+		// resource IDs are case-insensitive
+		{resourceID: strings.ToUpper(testShardingVaultA), count: 3, shard: 2},
+		{resourceID: strings.ToLower(testShardingVaultB), count: 4, shard: 3},
+	}
+
+	for _, test := range tests {
+		sharding := &KeyVaultSharding{Count: test.count}
+		if shard := sharding.Shard(test.resourceID); shard != test.shard {
+			t.Errorf(`expected shard %v for "%s" (shard count %v), got %v`, test.shard, test.resourceID, test.count, shard)
+		}
+	}
+}
+
+func TestKeyVaultShardingResize(t *testing.T) {
+	for count := 1; count < 16; count++ {
+		current := &KeyVaultSharding{Count: count}
+		resized := &KeyVaultSharding{Count: count + 1}
+
+		moved := 0
+		for i := 0; i < 1000; i++ {
+			resourceID := fmt.Sprintf("/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg/providers/Microsoft.KeyVault/vaults/kv-%v", i)
+
+			currentShard := current.Shard(resourceID)
+			resizedShard := resized.Shard(resourceID)
+			if currentShard == resizedShard {
+				continue
+			}
+
+			// only KeyVaults of the added shard are allowed to move
+			if resizedShard != count {
+				t.Fatalf(`"%s" moved from shard %v to %v when adding shard %v`, resourceID, currentShard, resizedShard, count)
+			}
+			moved++
+		}
+
+		if moved == 0 {
+			t.Errorf(`no KeyVault moved to added shard %v`, count)
+		}
+	}
+}
+
+func TestNewKeyVaultSharding(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    int
+		count    int
+		podName  string
+		expected *KeyVaultSharding
+		err      bool
+	}{
+		{name: "disabled", index: 5, count: 0, expected: &KeyVaultSharding{Index: 0, Count: 1}},
+		{name: "single", index: -1, count: 1, expected: &KeyVaultSharding{Index: 0, Count: 1}},
+		{name: "explicit", index: 2, count: 3, expected: &KeyVaultSharding{Index: 2, Count: 3}},
+		{name: "hostname", index: -1, count: 3, podName: "azure-keyvault-exporter-1", expected: &KeyVaultSharding{Index: 1, Count: 3}},
+		{name: "hostname-multidigit", index: -1, count: 16, podName: "azure-keyvault-exporter-12", expected: &KeyVaultSharding{Index: 12, Count: 16}},
+		{name: "hostname-without-ordinal", index: -1, count: 3, podName: "azure-keyvault-exporter", err: true},
+		{name: "hostname-invalid-ordinal", index: -1, count: 3, podName: "azure-keyvault-exporter-99999999999999999999", err: true},
+		{name: "hostname-out-of-range", index: -1, count: 3, podName: "azure-keyvault-exporter-3", err: true},
+		{name: "out-of-range", index: 3, count: 3, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("POD_NAME", test.podName)
+
+			sharding, err := NewKeyVaultSharding(test.index, test.count)
+			if test.err {
+				if err == nil {
+					t.Fatalf(`expected error, got %+v`, sharding)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf(`unexpected error: %v`, err)
+			}
+
+			if *sharding != *test.expected {
+				t.Errorf(`expected %+v, got %+v`, test.expected, sharding)
+			}
+		})
+	}
+}
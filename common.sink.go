@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sync"
+)
+
+var (
+	// enabled metric sinks (push, textfile)
+	metricsSinks MetricsSinkList
+)
+
+type (
+	// MetricsSink runs a metrics output (eg. push or textfile write) after each collection run, in one-shot mode only
+	// once after all collectors finished their first run
+	MetricsSink struct {
+		once   bool
+		output func() error
+
+		trigger chan struct{}
+
+		// collectors which have to finish (or restore from cache) before the one-shot output
+		collectors map[string]bool
+		lock       sync.Mutex
+
+		// exit code of one-shot output
+		done chan int
+	}
+
+	MetricsSinkList []*MetricsSink
+)
+
+// startMetricsSink creates and starts metrics sink, output is called after collection runs (errors have to be reported by output)
+func startMetricsSink(once bool, output func() error) {
+	sink := &MetricsSink{
+		once:       once,
+		output:     output,
+		trigger:    make(chan struct{}, 1),
+		collectors: map[string]bool{},
+		done:       make(chan int, 1),
+	}
+	metricsSinks = append(metricsSinks, sink)
+
+	go sink.run()
+}
+
+// registerCollector adds enabled collector, one-shot output waits for its first run
+func (s *MetricsSink) registerCollector(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.collectors[name] = false
+}
+
+// collectorFinished triggers output after collection run (or cache restore) of collector (non blocking)
+func (s *MetricsSink) collectorFinished(name string) {
+	s.lock.Lock()
+	s.collectors[name] = true
+	s.lock.Unlock()
+
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// output already pending
+	}
+}
+
+// finished returns true if all collectors finished their first run
+func (s *MetricsSink) finished() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, finished := range s.collectors {
+		if !finished {
+			return false
+		}
+	}
+	return true
+}
+
+// run calls output when triggered, in one-shot mode only once after all collectors finished
+func (s *MetricsSink) run() {
+	for range s.trigger {
+		if s.once && !s.finished() {
+			continue
+		}
+
+		if !leaderElection.IsLeader() {
+			// follower, metrics would be duplicated
+			continue
+		}
+
+		err := s.output()
+
+		if s.once {
+			exitCode := 0
+			if err != nil {
+				exitCode = 1
+			}
+			s.done <- exitCode
+			return
+		}
+	}
+}
+
+// registerCollector adds enabled collector to all sinks
+func (l MetricsSinkList) registerCollector(name string) {
+	for _, sink := range l {
+		sink.registerCollector(name)
+	}
+}
+
+// collectorFinished triggers output of all sinks
+func (l MetricsSinkList) collectorFinished(name string) {
+	for _, sink := range l {
+		sink.collectorFinished(name)
+	}
+}
+
+// Wait waits for all one-shot sinks and returns the highest exit code
+func (l MetricsSinkList) Wait() int {
+	exitCode := 0
+	for _, sink := range l {
+		if sink.once {
+			exitCode = max(exitCode, <-sink.done)
+		}
+	}
+	return exitCode
+}
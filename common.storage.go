@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// newAzBlobClient creates azblob client (detail cache, leader election) for storage account of url (azblob://storageaccount.blob.core.windows.net/...)
+// using the credential of the storage target (--azure.storage-target)
+func newAzBlobClient(parsedUrl *url.URL) (*azblob.Client, error) {
+	target, err := storageAzureTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	azblobOpts := azblob.ClientOptions{ClientOptions: *target.Client.NewAzCoreClientOptions()}
+	return azblob.NewClient(fmt.Sprintf(`https://%v/`, parsedUrl.Hostname()), target.GetCred(), &azblobOpts)
+}
+
+// storageAzureTarget returns Azure target whose credential is used for azblob storage
+func storageAzureTarget() (*AzureTarget, error) {
+	if len(AzureTargets) == 0 {
+		return nil, fmt.Errorf(`no Azure target found for azblob storage`)
+	}
+
+	if Opts.Azure.StorageTarget == "" {
+		return AzureTargets[0], nil
+	}
+
+	for _, target := range AzureTargets {
+		if target.Name == Opts.Azure.StorageTarget {
+			return target, nil
+		}
+	}
+
+	return nil, fmt.Errorf(`Azure target "%s" for azblob storage not found`, Opts.Azure.StorageTarget)
+}
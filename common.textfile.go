@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/webdevops/go-common/prometheus/collector"
+)
+
+const (
+	// only KeyVault metrics are written, other metrics (eg. go_*) would collide with node-exporter metrics
+	textfileMetricsPrefix = "azurerm_keyvault_"
+)
+
+var (
+	prometheusTextfileWrite *prometheus.CounterVec
+)
+
+type (
+	// MetricsTextfileWriter writes the gathered KeyVault metrics to a file for the node-exporter textfile collector after each collection run
+	MetricsTextfileWriter struct {
+		path string
+	}
+)
+
+func init() {
+	prometheusTextfileWrite = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_textfile_write_total",
+			Help: "Azure KeyVault exporter: metrics textfile writes by result",
+		},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(prometheusTextfileWrite)
+}
+
+// initMetricsTextfile starts writing of metrics textfile (if enabled)
+func initMetricsTextfile() {
+	if Opts.Textfile.Path == "" {
+		if Opts.Textfile.Once {
+			logger.Fatal(`--textfile.once needs a textfile directory (--textfile.path)`)
+		}
+		return
+	}
+
+	if !strings.HasSuffix(Opts.Textfile.Name, ".prom") || filepath.Base(Opts.Textfile.Name) != Opts.Textfile.Name {
+		logger.Fatalf(`invalid textfile name "%s", expected file name with .prom suffix`, Opts.Textfile.Name)
+	}
+
+	if stat, err := os.Stat(Opts.Textfile.Path); err != nil || !stat.IsDir() {
+		logger.Fatalf(`textfile directory "%s" does not exist`, Opts.Textfile.Path)
+	}
+
+	if Opts.Textfile.Once && Opts.LeaderElection.Backend != LeaderElectionBackendNone {
+		logger.Fatal(`--textfile.once can not be used with leader election`)
+	}
+
+	writer := &MetricsTextfileWriter{
+		path: filepath.Join(filepath.Clean(Opts.Textfile.Path), Opts.Textfile.Name),
+	}
+
+	startMetricsSink(Opts.Textfile.Once, writer.output)
+
+	logger.Infof(`enabled writing of metrics to %s`, writer.path)
+}
+
+// output writes metrics file and counts the result
+func (w *MetricsTextfileWriter) output() error {
+	err := w.write()
+	if err != nil {
+		prometheusTextfileWrite.WithLabelValues(InstrumentationResultFailed).Inc()
+		logger.Errorf(`writing metrics to %s failed: %v`, w.path, err)
+	} else {
+		prometheusTextfileWrite.WithLabelValues(InstrumentationResultSuccess).Inc()
+		logger.Debugf(`wrote metrics to %s`, w.path)
+	}
+	return err
+}
+
+// write writes KeyVault metrics in text format to a temporary file and renames it (atomic replace, node-exporter
+// never reads a partially written file)
+func (w *MetricsTextfileWriter) write() (err error) {
+	// wait until running collector finished setting its metrics
+	collector.Lock().RLock()
+	families, err := prometheus.DefaultGatherer.Gather()
+	collector.Lock().RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// temporary file without .prom suffix in same directory (rename must not cross filesystems)
+	file, err := os.CreateTemp(filepath.Dir(w.path), "."+filepath.Base(w.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()           // nolint: errcheck
+			os.Remove(file.Name()) // nolint: errcheck
+		}
+	}()
+
+	buf := bufio.NewWriter(file)
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), textfileMetricsPrefix) || len(family.GetMetric()) == 0 {
+			continue
+		}
+
+		if _, err = expfmt.MetricFamilyToText(buf, family); err != nil {
+			return fmt.Errorf(`unable to encode metric "%s": %w`, family.GetName(), err)
+		}
+	}
+
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+
+	// node-exporter usually runs as different user
+	if err = file.Chmod(0644); err != nil { // #nosec G302 metrics file has to be readable by node-exporter
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), w.path)
+}
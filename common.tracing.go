@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	azureTracing "github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	TracingExporterNone     = "none"
+	TracingExporterOtlpGrpc = "otlp-grpc"
+	TracingExporterOtlpHttp = "otlp-http"
+	TracingExporterStdout   = "stdout"
+	TracingExporterFile     = "file"
+
+	tracingInstrumentationName = "github.com/webdevops/azure-keyvault-exporter"
+)
+
+var (
+	// tracer of exporter spans (no-op if tracing is disabled)
+	tracer = otel.Tracer(tracingInstrumentationName)
+
+	// tracing provider for Azure SDK clients (nil if tracing is disabled)
+	azureTracingProvider *azureTracing.Provider
+)
+
+// initTracing starts OpenTelemetry tracing (if enabled)
+func initTracing() {
+	if Opts.Tracing.Exporter == "" || Opts.Tracing.Exporter == TracingExporterNone {
+		return
+	}
+
+	ctx := context.Background()
+
+	exporter, err := newTracingExporter(ctx)
+	if err != nil {
+		logger.Fatalf(`unable to create tracing exporter: %v`, err)
+	}
+
+	traceResource, err := newOtelResource(ctx)
+	if err != nil {
+		logger.Fatalf(`unable to create tracing resource: %v`, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(traceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(Opts.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	azureProvider := newAzureTracingProvider()
+	azureTracingProvider = &azureProvider
+
+	logger.Infof(`enabled tracing using %s exporter (sample ratio %v)`, Opts.Tracing.Exporter, Opts.Tracing.SampleRatio)
+}
+
+// newOtelResource creates OpenTelemetry resource of exporter (traces and OTLP metrics), additional attributes can be set by OTEL_RESOURCE_ATTRIBUTES
+func newOtelResource(ctx context.Context) (*resource.Resource, error) {
+	return resource.New(
+		ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", Opts.Tracing.ServiceName),
+			attribute.String("service.version", gitTag),
+		),
+	)
+}
+
+// newTracingExporter creates span exporter, OTLP exporters are also configured by OTEL_EXPORTER_OTLP_* env vars
+func newTracingExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	switch Opts.Tracing.Exporter {
+	case TracingExporterOtlpGrpc:
+		opts := []otlptracegrpc.Option{}
+		if Opts.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(Opts.Tracing.Endpoint))
+		}
+		if Opts.Tracing.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case TracingExporterOtlpHttp:
+		opts := []otlptracehttp.Option{}
+		if Opts.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(Opts.Tracing.Endpoint))
+		}
+		if Opts.Tracing.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case TracingExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case TracingExporterFile:
+		if Opts.Tracing.Path == "" {
+			return nil, fmt.Errorf(`file exporter needs --tracing.path`)
+		}
+
+		file, err := os.OpenFile(filepath.Clean(Opts.Tracing.Path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(file))
+	}
+
+	return nil, fmt.Errorf(`tracing exporter "%s" not supported`, Opts.Tracing.Exporter)
+}
+
+// tracingLogger adds trace and span ID of context to logger (for correlation of logs and traces)
+func tracingLogger(ctx context.Context, logger *zap.SugaredLogger) *zap.SugaredLogger {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return logger
+	}
+
+	return logger.With(
+		zap.String("traceID", spanContext.TraceID().String()),
+		zap.String("spanID", spanContext.SpanID().String()),
+	)
+}
+
+// tracingEnd sets error status of span (if err is set) and ends it
+func tracingEnd(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// newAzureTracingProvider creates Azure SDK tracing provider which creates OpenTelemetry spans (for each Azure SDK request)
+func newAzureTracingProvider() azureTracing.Provider {
+	return azureTracing.NewProvider(func(name, version string) azureTracing.Tracer {
+		otelTracer := otel.Tracer(name, trace.WithInstrumentationVersion(version))
+
+		return azureTracing.NewTracer(
+			func(ctx context.Context, spanName string, options *azureTracing.SpanOptions) (context.Context, azureTracing.Span) {
+				opts := []trace.SpanStartOption{}
+				if options != nil {
+					// azcore span kinds have the same values as OpenTelemetry span kinds
+					opts = append(opts, trace.WithSpanKind(trace.SpanKind(options.Kind)), trace.WithAttributes(azureTracingAttributes(options.Attributes)...))
+				}
+
+				ctx, span := otelTracer.Start(ctx, spanName, opts...)
+				return ctx, newAzureTracingSpan(span)
+			},
+			&azureTracing.TracerOptions{
+				SpanFromContext: func(ctx context.Context) azureTracing.Span {
+					return newAzureTracingSpan(trace.SpanFromContext(ctx))
+				},
+			},
+		)
+	}, nil)
+}
+
+// newAzureTracingSpan wraps OpenTelemetry span as Azure SDK span
+func newAzureTracingSpan(span trace.Span) azureTracing.Span {
+	return azureTracing.NewSpan(azureTracing.SpanImpl{
+		End: func() {
+			span.End()
+		},
+		SetAttributes: func(attrs ...azureTracing.Attribute) {
+			span.SetAttributes(azureTracingAttributes(attrs)...)
+		},
+		AddEvent: func(name string, attrs ...azureTracing.Attribute) {
+			span.AddEvent(name, trace.WithAttributes(azureTracingAttributes(attrs)...))
+		},
+		SetStatus: func(status azureTracing.SpanStatus, description string) {
+			// azcore span status has the same values as OpenTelemetry status codes
+			span.SetStatus(codes.Code(status), description) // #nosec G115 status values are 0-2
+		},
+	})
+}
+
+// azureTracingAttributes converts Azure SDK attributes to OpenTelemetry attributes
+func azureTracingAttributes(attrs []azureTracing.Attribute) []attribute.KeyValue {
+	ret := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch value := attr.Value.(type) {
+		case int64:
+			ret = append(ret, attribute.Int64(attr.Key, value))
+		case int:
+			ret = append(ret, attribute.Int(attr.Key, value))
+		case float64:
+			ret = append(ret, attribute.Float64(attr.Key, value))
+		case bool:
+			ret = append(ret, attribute.Bool(attr.Key, value))
+		case string:
+			ret = append(ret, attribute.String(attr.Key, value))
+		default:
+			ret = append(ret, attribute.String(attr.Key, fmt.Sprintf("%v", value)))
+		}
+	}
+	return ret
+}
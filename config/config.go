@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	CredentialTypeDefault           = "default"
+	CredentialTypeClientSecret      = "clientsecret"
+	CredentialTypeClientCertificate = "clientcertificate"
+	CredentialTypeWorkloadIdentity  = "workloadidentity"
+	CredentialTypeManagedIdentity   = "managedidentity"
+)
+
+type (
+	Config struct {
+		Targets []ConfigTarget `yaml:"targets" json:"targets"`
+	}
+
+	ConfigTarget struct {
+		Name          string                 `yaml:"name"          json:"name"`
+		Environment   string                 `yaml:"environment"   json:"environment"`
+		Tenant        string                 `yaml:"tenant"        json:"tenant"`
+		Subscriptions []string               `yaml:"subscriptions" json:"subscriptions"`
+		Scope         ConfigTargetScope      `yaml:"scope"         json:"scope"`
+		Vaults        []ConfigVault          `yaml:"vaults"        json:"vaults"`
+		Credential    ConfigTargetCredential `yaml:"credential"    json:"credential"`
+	}
+
+	ConfigVault struct {
+		URL        string            `yaml:"url"        json:"url"`
+		Name       string            `yaml:"name"       json:"name"`
+		ResourceID string            `yaml:"resourceID" json:"resourceID"`
+		Labels     map[string]string `yaml:"labels"     json:"labels"`
+	}
+
+	ConfigTargetScope struct {
+		ManagementGroups        []string `yaml:"managementGroups"        json:"managementGroups"`
+		SubscriptionName        string   `yaml:"subscriptionName"        json:"subscriptionName"`
+		SubscriptionTagSelector string   `yaml:"subscriptionTagSelector" json:"subscriptionTagSelector"`
+		SubscriptionState       []string `yaml:"subscriptionState"       json:"subscriptionState"`
+	}
+
+	ConfigTargetCredential struct {
+		Type                string `yaml:"type"                json:"type"`
+		ClientID            string `yaml:"clientID"            json:"clientID"`
+		ClientSecret        string `yaml:"clientSecret"        json:"-"`
+		CertificatePath     string `yaml:"certificatePath"     json:"certificatePath"`
+		CertificatePassword string `yaml:"certificatePassword" json:"-"`
+		FederatedTokenFile  string `yaml:"federatedTokenFile"  json:"federatedTokenFile"`
+	}
+)
+
+// NewConfigFromFile reads config from yaml file, environment variables (${VAR}) are expanded
+func NewConfigFromFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path) // #nosec G304 config file path is provided by user
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(content))), conf); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// Validate validates configuration
+func (c *Config) Validate() error {
+	targetNames := map[string]bool{}
+	for i := range c.Targets {
+		target := &c.Targets[i]
+
+		if target.Name == "" {
+			return fmt.Errorf(`target #%d: name is required`, i)
+		}
+
+		if _, exists := targetNames[target.Name]; exists {
+			return fmt.Errorf(`target "%s": name is not unique`, target.Name)
+		}
+		targetNames[target.Name] = true
+
+		target.Credential.Type = strings.ToLower(target.Credential.Type)
+		switch target.Credential.Type {
+		case "":
+			target.Credential.Type = CredentialTypeDefault
+		case CredentialTypeDefault:
+		case CredentialTypeClientSecret:
+			if target.Tenant == "" || target.Credential.ClientID == "" || target.Credential.ClientSecret == "" {
+				return fmt.Errorf(`target "%s": tenant, clientID and clientSecret are required for credential type "%s"`, target.Name, target.Credential.Type)
+			}
+		case CredentialTypeClientCertificate:
+			if target.Tenant == "" || target.Credential.ClientID == "" || target.Credential.CertificatePath == "" {
+				return fmt.Errorf(`target "%s": tenant, clientID and certificatePath are required for credential type "%s"`, target.Name, target.Credential.Type)
+			}
+		case CredentialTypeWorkloadIdentity:
+			if target.Tenant == "" || target.Credential.ClientID == "" {
+				return fmt.Errorf(`target "%s": tenant and clientID are required for credential type "%s"`, target.Name, target.Credential.Type)
+			}
+		case CredentialTypeManagedIdentity:
+		default:
+			return fmt.Errorf(`target "%s": credential type "%s" is not supported`, target.Name, target.Credential.Type)
+		}
+	}
+
+	return nil
+}
@@ -14,36 +14,154 @@ type (
 			Json        bool `long:"log.json"     env:"LOG_JSON"   description:"Switch log output to json format"`
 		}
 
+		// config
+		Config struct {
+			Path string `long:"config"  env:"CONFIG"  description:"Path to config file (yaml), eg. for multiple Azure targets (tenants/credentials)"`
+		}
+
 		// azure
 		Azure struct {
-			Environment  *string  `long:"azure.environment"       env:"AZURE_ENVIRONMENT"                        description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
-			Subscription []string `long:"azure.subscription"      env:"AZURE_SUBSCRIPTION_ID"     env-delim:" "  description:"Azure subscription ID (space delimiter)"`
-			ResourceTags []string `long:"azure.resource-tag"      env:"AZURE_RESOURCE_TAG"        env-delim:" "  description:"Azure Resource tags (space delimiter)"                              default:"owner"`
+			Environment             *string  `long:"azure.environment"                env:"AZURE_ENVIRONMENT"                             description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
+			Subscription            []string `long:"azure.subscription"               env:"AZURE_SUBSCRIPTION_ID"            env-delim:" "  description:"Azure subscription ID (space delimiter)"`
+			SubscriptionName        string   `long:"azure.subscription.name"          env:"AZURE_SUBSCRIPTION_NAME"                       description:"Filter Azure subscriptions by display name (regex)"`
+			SubscriptionTagSelector string   `long:"azure.subscription.tag-selector"  env:"AZURE_SUBSCRIPTION_TAG_SELECTOR"               description:"Filter Azure subscriptions by tags (kubernetes label selector syntax, eg. 'env=prod,team in (a,b)')"`
+			SubscriptionState       []string `long:"azure.subscription.state"         env:"AZURE_SUBSCRIPTION_STATE"         env-delim:" "  description:"Filter Azure subscriptions by state (Enabled, Warned, PastDue) (space delimiter)"`
+			ManagementGroup         []string `long:"azure.managementgroup"            env:"AZURE_MANAGEMENTGROUP"            env-delim:" "  description:"Azure management group ID, subscriptions are discovered recursively (space delimiter)"`
+			ResourceTags            []string `long:"azure.resource-tag"               env:"AZURE_RESOURCE_TAG"               env-delim:" "  description:"Azure Resource tags (space delimiter)"                              default:"owner"`
+			StorageTarget           string   `long:"azure.storage-target"             env:"AZURE_STORAGE_TARGET"                          description:"Azure target (name) whose credential is used for azblob storage of the detail cache and leader election (default: first target)"`
 		}
 
 		KeyVault struct {
-			Filter  string `long:"keyvault.filter"   env:"KEYVAULT_FILTER"   description:"Filter KeyVaults via ResourceGraph kusto filter, query: 'resource | ${filter} | project id'"`
-			Content struct {
-				Tags []string `long:"keyvault.content.tag"      env:"KEYVAULT_CONTENT_TAG"        env-delim:" "  description:"KeyVault content (secret, key, certificates) tags (space delimiter)"`
+			Filter    string   `long:"keyvault.filter"     env:"KEYVAULT_FILTER"     description:"Filter KeyVaults via ResourceGraph kusto filter, query: 'resource | ${filter} | project id'"`
+			Url       []string `long:"keyvault.url"       env:"KEYVAULT_URL"       env-delim:" "  description:"Static list of KeyVault urls, only KeyVault data-plane access is used (no ARM/subscription lookups) (space delimiter)"`
+			Discovery string   `long:"keyvault.discovery"  env:"KEYVAULT_DISCOVERY"  description:"KeyVault discovery mode (arm: list KeyVaults per subscription, resourcegraph: single ResourceGraph query)"  default:"arm"  choice:"arm"  choice:"resourcegraph"`
+			Content   struct {
+				Tags       []string `long:"keyvault.content.tag"          env:"KEYVAULT_CONTENT_TAG"          env-delim:" "  description:"KeyVault content (secret, key, certificates) tags (space delimiter)"`
+				TagSource  []string `long:"keyvault.content.tag.source"   env:"KEYVAULT_CONTENT_TAG_SOURCE"   env-delim:" "  description:"KeyVault content tag value sources in order of precedence (item, vault) (space delimiter)"  default:"item"`
+				TagDefault string   `long:"keyvault.content.tag.default"  env:"KEYVAULT_CONTENT_TAG_DEFAULT"                 description:"KeyVault content tag default value if tag is not found in any source"`
+				TagStatus  bool     `long:"keyvault.content.tag.status"   env:"KEYVAULT_CONTENT_TAG_STATUS"                  description:"Add KeyVault content tags also to status metrics (*_status)"`
+			}
+			Stale struct {
+				MaxAge time.Duration `long:"keyvault.stale.maxage"  env:"KEYVAULT_STALE_MAXAGE"  description:"Max age of last known good KeyVault content data which is used if collection fails (0 = disabled)"  default:"0"`
 			}
 		}
 
 		// caching
 		Cache struct {
-			Path string `long:"cache.path" env:"CACHE_PATH" description:"Cache path (to folder, file://path... or azblob://storageaccount.blob.core.windows.net/containername)"`
+			Path string `long:"cache.path" env:"CACHE_PATH" description:"Cache path (to folder, file://path... or azblob://storageaccount.blob.core.windows.net/containername), k8scm:// is not supported with --scrape.time.details"`
 		}
 
 		// scrape times
 		Scrape struct {
-			Time        time.Duration `long:"scrape.time"         env:"SCRAPE_TIME"         description:"Default scrape time (time.duration)"                         default:"5m"`
-			Concurrency int           `long:"scrape.concurrency"  env:"SCRAPE_CONCURRENCY"  description:"Defines who many Keyvaults can be scraped at the same time"  default:"10"`
+			Time             time.Duration `long:"scrape.time"            env:"SCRAPE_TIME"            description:"Scrape time of KeyVault content (keys, secrets, certificates) (time.duration, 0 = disabled)"  default:"5m"`
+			TimeInventory    time.Duration `long:"scrape.time.inventory"  env:"SCRAPE_TIME_INVENTORY"  description:"Scrape time of KeyVault inventory (discovery and metadata) (time.duration, 0 = disabled, KeyVaults are discovered on each content scrape)"  default:"0"`
+			TimeDetails      time.Duration `long:"scrape.time.details"    env:"SCRAPE_TIME_DETAILS"    description:"Scrape time of key and certificate details (key type/size, issuer, subject, auto renew) (time.duration, 0 = disabled), GetKey and GetCertificatePolicy results are cached and only fetched for new or updated items"`
+			Concurrency      int           `long:"scrape.concurrency"  env:"SCRAPE_CONCURRENCY"  description:"Defines who many Keyvaults can be scraped at the same time"  default:"10"`
+			VaultConcurrency int           `long:"scrape.concurrency.vault"  env:"SCRAPE_CONCURRENCY_VAULT"  description:"Defines how many listings (keys, secrets, certificates) per KeyVault are done at the same time"  default:"3"`
+			Timeout          time.Duration `long:"scrape.timeout"        env:"SCRAPE_TIMEOUT"        description:"Overall collection deadline, unfinished KeyVaults are reported with status timeout (0 = disabled)"`
+			VaultTimeout     time.Duration `long:"scrape.timeout.vault"  env:"SCRAPE_TIMEOUT_VAULT"  description:"Collection timeout per KeyVault (0 = disabled)"  default:"0"`
+			Schedule         struct {
+				Tiers   []string      `long:"scrape.schedule.tier"     env:"SCRAPE_SCHEDULE_TIER"     env-delim:" "  description:"Scrape interval tiers by nearest upcoming expiry of KeyVault content, format expiry:interval (eg. '2d:5m 14d:30m 60d:2h') (space delimiter)"`
+				Default time.Duration `long:"scrape.schedule.default"  env:"SCRAPE_SCHEDULE_DEFAULT"                 description:"Scrape interval of KeyVaults without upcoming expiry within the tiers (0 = --scrape.time)"`
+				Jitter  float64       `long:"scrape.schedule.jitter"   env:"SCRAPE_SCHEDULE_JITTER"                  description:"Random jitter of scrape interval (fraction, eg. 0.1 = +-10%)"  default:"0.1"`
+			}
+		}
+
+		// sharding
+		Sharding struct {
+			Index int `long:"sharding.index"  env:"SHARDING_INDEX"  description:"Shard index of this replica (-1 = detect from StatefulSet pod ordinal, POD_NAME or hostname)"  default:"-1"`
+			Count int `long:"sharding.count"  env:"SHARDING_COUNT"  description:"Number of shards (replicas), KeyVaults are assigned by consistent hashing of the resource ID (1 = disabled)"  default:"1"`
+		}
+
+		// leader election
+		LeaderElection struct {
+			Backend       string        `long:"leaderelection.backend"        env:"LEADERELECTION_BACKEND"        description:"Leader election backend, only the leader collects metrics (none, kubernetes: Lease, azblob: blob lease, file: file lock)"  default:"none"  choice:"none"  choice:"kubernetes"  choice:"azblob"  choice:"file"`
+			Name          string        `long:"leaderelection.name"           env:"LEADERELECTION_NAME"           description:"Leader election lock name (Lease name or lock blob/file name)"                                                               default:"azure-keyvault-exporter"`
+			Namespace     string        `long:"leaderelection.namespace"      env:"LEADERELECTION_NAMESPACE"      description:"Kubernetes namespace of Lease (default: POD_NAMESPACE or namespace of service account)"`
+			Path          string        `long:"leaderelection.path"           env:"LEADERELECTION_PATH"           description:"Lock location for azblob (azblob://storageaccount.blob.core.windows.net/container) or file backend (folder, default: temp folder)"`
+			Identity      string        `long:"leaderelection.identity"       env:"LEADERELECTION_IDENTITY"       description:"Identity of this replica (default: POD_NAME or hostname)"`
+			LeaseDuration time.Duration `long:"leaderelection.lease-duration" env:"LEADERELECTION_LEASE_DURATION" description:"Leader lease duration, followers wait this duration before taking over"                                                       default:"15s"`
+			RenewDeadline time.Duration `long:"leaderelection.renew-deadline" env:"LEADERELECTION_RENEW_DEADLINE" description:"Leader gives up leadership if lease could not be renewed within this duration"                                                default:"10s"`
+			RetryPeriod   time.Duration `long:"leaderelection.retry-period"   env:"LEADERELECTION_RETRY_PERIOD"   description:"Interval of lease acquire and renew attempts"                                                                               default:"2s"`
+		}
+
+		// rate limits
+		RateLimit struct {
+			Vault             float64 `long:"ratelimit.vault"               env:"RATELIMIT_VAULT"               description:"Max requests per second per KeyVault (data-plane) (0 = disabled)"  default:"0"`
+			VaultBurst        int     `long:"ratelimit.vault.burst"         env:"RATELIMIT_VAULT_BURST"         description:"Max burst requests per KeyVault (data-plane)"                      default:"100"`
+			Subscription      float64 `long:"ratelimit.subscription"        env:"RATELIMIT_SUBSCRIPTION"        description:"Max requests per second per subscription (ARM, incl. discovery, ResourceGraph and resource tag lookups) (0 = disabled)"     default:"0"`
+			SubscriptionBurst int     `long:"ratelimit.subscription.burst"  env:"RATELIMIT_SUBSCRIPTION_BURST"  description:"Max burst requests per subscription (ARM)"                         default:"50"`
+		}
+
+		// tracing
+		Tracing struct {
+			Exporter    string  `long:"tracing.exporter"      env:"TRACING_EXPORTER"      description:"OpenTelemetry tracing exporter (none, otlp-grpc, otlp-http, stdout, file), OTLP exporters are also configured by OTEL_EXPORTER_OTLP_* env vars"  default:"none"  choice:"none"  choice:"otlp-grpc"  choice:"otlp-http"  choice:"stdout"  choice:"file"`
+			Endpoint    string  `long:"tracing.endpoint"      env:"TRACING_ENDPOINT"      description:"OTLP endpoint url (eg. http://otel-collector:4317, default: OTEL_EXPORTER_OTLP_ENDPOINT)"`
+			Insecure    bool    `long:"tracing.insecure"      env:"TRACING_INSECURE"      description:"Disable TLS for OTLP exporter"`
+			Path        string  `long:"tracing.path"          env:"TRACING_PATH"          description:"Path of trace file (file exporter)"`
+			ServiceName string  `long:"tracing.service-name"  env:"TRACING_SERVICE_NAME"  description:"Service name of traces and OTLP metrics (resource attribute service.name)"  default:"azure-keyvault-exporter"`
+			SampleRatio float64 `long:"tracing.sample-ratio"  env:"TRACING_SAMPLE_RATIO"  description:"Ratio of sampled collection traces (0-1)"                                     default:"1"`
+		}
+
+		// OTLP metrics push
+		OtlpMetrics struct {
+			Protocol      string        `long:"otlp.metrics.protocol"         env:"OTLP_METRICS_PROTOCOL"         description:"Push KeyVault metrics via OTLP (none, grpc, http), /metrics is still available"  default:"none"  choice:"none"  choice:"grpc"  choice:"http"`
+			Endpoint      string        `long:"otlp.metrics.endpoint"         env:"OTLP_METRICS_ENDPOINT"         description:"OTLP endpoint url (eg. http://otel-collector:4317 (grpc) or http://otel-collector:4318/v1/metrics (http), default: OTEL_EXPORTER_OTLP_METRICS_ENDPOINT)"`
+			Headers       []string      `long:"otlp.metrics.header"           env:"OTLP_METRICS_HEADER"           env-delim:" "  description:"Additional OTLP request headers, format key=value (eg. for authentication) (space delimiter)"  json:"-"`
+			Insecure      bool          `long:"otlp.metrics.insecure"         env:"OTLP_METRICS_INSECURE"         description:"Disable TLS"`
+			TlsCa         string        `long:"otlp.metrics.tls.ca"           env:"OTLP_METRICS_TLS_CA"           description:"Path of CA certificate (PEM) to verify the OTLP endpoint"`
+			TlsCert       string        `long:"otlp.metrics.tls.cert"         env:"OTLP_METRICS_TLS_CERT"         description:"Path of client certificate (PEM) for mTLS"`
+			TlsKey        string        `long:"otlp.metrics.tls.key"          env:"OTLP_METRICS_TLS_KEY"          description:"Path of client certificate key (PEM) for mTLS"`
+			TlsSkipVerify bool          `long:"otlp.metrics.tls.skip-verify"  env:"OTLP_METRICS_TLS_SKIP_VERIFY"  description:"Skip verification of OTLP endpoint certificate"`
+			Interval      time.Duration `long:"otlp.metrics.interval"         env:"OTLP_METRICS_INTERVAL"         description:"Push interval, metrics are also pushed after each collection run (0 = only after collection runs)"  default:"1m"`
+			Timeout       time.Duration `long:"otlp.metrics.timeout"          env:"OTLP_METRICS_TIMEOUT"          description:"Push timeout"                                                                                     default:"30s"`
 		}
 
+		// push mode
+		Push struct {
+			Mode            string        `long:"push.mode"               env:"PUSH_MODE"               description:"Push metrics after each collection run (none, pushgateway, remotewrite), /metrics is still available"  default:"none"  choice:"none"  choice:"pushgateway"  choice:"remotewrite"`
+			Url             string        `long:"push.url"                env:"PUSH_URL"                description:"Pushgateway url (eg. http://pushgateway:9091) or remote-write url (eg. http://prometheus:9090/api/v1/write)"`
+			Job             string        `long:"push.job"                env:"PUSH_JOB"                description:"Job name (pushgateway job, job label for remote-write)"                                                  default:"azure-keyvault-exporter"`
+			Labels          []string      `long:"push.label"              env:"PUSH_LABEL"              env-delim:" "  description:"Additional labels, format key=value (pushgateway grouping key, labels of all remote-write series) (space delimiter)"`
+			Username        string        `long:"push.username"           env:"PUSH_USERNAME"           description:"Basic auth username"`
+			Password        string        `long:"push.password"           env:"PUSH_PASSWORD"           description:"Basic auth password"  json:"-"`
+			BearerToken     string        `long:"push.bearer-token"       env:"PUSH_BEARER_TOKEN"       description:"Bearer token"         json:"-"`
+			BearerTokenFile string        `long:"push.bearer-token-file"  env:"PUSH_BEARER_TOKEN_FILE"  description:"Path of bearer token file (read on each push, eg. for rotated tokens)"`
+			Retries         int           `long:"push.retries"            env:"PUSH_RETRIES"            description:"Retries of failed pushes"                                                                               default:"3"`
+			RetryWait       time.Duration `long:"push.retry-wait"         env:"PUSH_RETRY_WAIT"         description:"Wait time before first retry (doubled on each retry)"                                                   default:"5s"`
+			Timeout         time.Duration `long:"push.timeout"            env:"PUSH_TIMEOUT"            description:"Push timeout (per attempt)"                                                                             default:"30s"`
+			Once            bool          `long:"push.once"               env:"PUSH_ONCE"               description:"Run all enabled collectors once, push metrics and exit (eg. for cron jobs), exit code is 1 if push failed"`
+		}
+
+		// node-exporter textfile collector
+		Textfile struct {
+			Path string `long:"textfile.path"  env:"TEXTFILE_PATH"  description:"Write KeyVault metrics after each collection run to this directory (node-exporter textfile collector directory), /metrics is still available"`
+			Name string `long:"textfile.name"  env:"TEXTFILE_NAME"  description:"Name of metrics file (needs .prom suffix)"  default:"azure_keyvault.prom"`
+			Once bool   `long:"textfile.once"  env:"TEXTFILE_ONCE"  description:"Run all enabled collectors once, write metrics file and exit (eg. for cron jobs), exit code is 1 if writing failed"`
+		}
+
+		// probe
+		Probe struct {
+			Enable  bool          `long:"probe.enable"   env:"PROBE_ENABLE"   description:"Enable /probe endpoint for collection of single KeyVaults (/probe?target=https://myvault.vault.azure.net/)"`
+			Timeout time.Duration `long:"probe.timeout"  env:"PROBE_TIMEOUT"  description:"Max probe duration, limited by Prometheus scrape timeout (X-Prometheus-Scrape-Timeout-Seconds)"  default:"9s"`
+		}
+
+		// report command
+		Report struct {
+			Format    string        `long:"format"     env:"REPORT_FORMAT"     description:"Report format"  default:"json"  choice:"json"  choice:"csv"  choice:"html"`
+			Output    string        `long:"output"     env:"REPORT_OUTPUT"     description:"Report file (- = stdout)"  default:"-"`
+			Threshold time.Duration `long:"threshold"  env:"REPORT_THRESHOLD"  description:"Items expiring within this duration (or expired) are not compliant, exit code is 2 if there are not compliant items"  default:"720h"`
+		} `command:"report" description:"Collect all KeyVaults once and write an expiry report of all keys, secrets and certificates"`
+
 		Server struct {
 			// general options
 			Bind         string        `long:"server.bind"              env:"SERVER_BIND"           description:"Server address"        default:":8080"`
+			Disable      bool          `long:"server.disable"           env:"SERVER_DISABLE"        description:"Disable http server (only with OTLP metrics, push or textfile mode)"`
 			ReadTimeout  time.Duration `long:"server.timeout.read"      env:"SERVER_TIMEOUT_READ"   description:"Server read timeout"   default:"5s"`
 			WriteTimeout time.Duration `long:"server.timeout.write"     env:"SERVER_TIMEOUT_WRITE"  description:"Server write timeout"  default:"10s"`
+
+			// readiness
+			ReadyMaxAge time.Duration `long:"server.readyz.maxage"  env:"SERVER_READYZ_MAXAGE"  description:"Max age of last successful collection, /readyz fails if data is older (0 = 3x scrape time of collector)"`
 		}
 	}
 )
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/webdevops/go-common/prometheus/collector"
 	"go.uber.org/zap"
 
-	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/azuresdk/prometheus/tracing"
 
 	"github.com/webdevops/azure-keyvault-exporter/config"
@@ -26,10 +30,9 @@ const (
 var (
 	argparser *flags.Parser
 	Opts      config.Opts
+	AppConfig config.Config
 
-	AzureClient                *armclient.ArmClient
-	AzureSubscriptionsIterator *armclient.SubscriptionsIterator
-	AzureResourceTagManager    *armclient.ResourceTagManager
+	AzureTargets []*AzureTarget
 
 	// Git version information
 	gitCommit = "<unknown>"
@@ -46,19 +49,53 @@ func main() {
 	logger.Infof("starting azure-keyvault-exporter v%s (%s; %s; by %v)", gitTag, gitCommit, runtime.Version(), Author)
 	logger.Info(string(Opts.GetJson()))
 	initSystem()
+	initConfig()
+	initTracing()
+	initSharding()
 
 	logger.Infof("init Azure connection")
+	initRateLimiter()
 	initAzureConnection()
 
+	if argparser.Active != nil && argparser.Active.Name == "report" {
+		// one-shot report, no metrics collection
+		os.Exit(runReport())
+	}
+
+	initLeaderElection()
+	initOtlpMetrics()
+	initMetricsPush()
+	initMetricsTextfile()
+
+	if Opts.Server.Disable && otlpMetrics == nil && len(metricsSinks) == 0 {
+		logger.Fatal(`--server.disable needs OTLP metrics, push or textfile mode, otherwise metrics would not be available`)
+	}
+
 	logger.Infof("starting metrics collection")
 	initMetricCollector()
 
+	if Opts.Push.Once || Opts.Textfile.Once {
+		// one-shot mode, no http server
+		logger.Infof("waiting for collection runs and push/textfile write")
+		os.Exit(metricsSinks.Wait())
+	}
+
+	if Opts.Server.Disable {
+		// no http server, run until terminated
+		logger.Infof("http server disabled")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		return
+	}
+
 	logger.Infof("Starting http server on %s", Opts.Server.Bind)
 	startHttpServer()
 }
 
 func initArgparser() {
 	argparser = flags.NewParser(&Opts, flags.Default)
+	argparser.SubcommandsOptional = true
 	_, err := argparser.Parse()
 
 	// check if there is an parse error
@@ -74,40 +111,70 @@ func initArgparser() {
 	}
 }
 
-func initAzureConnection() {
-	var err error
-	AzureClient, err = armclient.NewArmClientWithCloudName(*Opts.Azure.Environment, logger)
-	if err != nil {
-		logger.Fatal(err.Error())
+func initConfig() {
+	if Opts.Scrape.TimeDetails > 0 && strings.HasPrefix(Opts.Cache.Path, "k8scm://") {
+		logger.Fatal(`--scrape.time.details does not support k8scm:// as --cache.path (ConfigMap size limit), use a folder, file:// or azblob://`)
 	}
 
-	AzureClient.SetUserAgent(UserAgent + gitTag)
+	if Opts.Config.Path == "" {
+		return
+	}
 
-	// limit subscriptions (if filter is set)
-	if len(Opts.Azure.Subscription) >= 1 {
-		AzureClient.SetSubscriptionID(Opts.Azure.Subscription...)
+	logger.Infof("reading config from %s", Opts.Config.Path)
+	conf, err := config.NewConfigFromFile(Opts.Config.Path)
+	if err != nil {
+		logger.Fatalf(`unable to read config "%s": %v`, Opts.Config.Path, err.Error())
 	}
+	AppConfig = *conf
+}
 
-	// init subscription iterator
-	AzureSubscriptionsIterator = armclient.NewSubscriptionIterator(AzureClient)
+func initAzureConnection() {
+	targetConfigList := AppConfig.Targets
+	if len(targetConfigList) == 0 {
+		// no targets configured, use default target from environment and args
+		targetConfigList = []config.ConfigTarget{
+			{
+				Name:          AzureDefaultTargetName,
+				Environment:   *Opts.Azure.Environment,
+				Subscriptions: Opts.Azure.Subscription,
+				Vaults:        []config.ConfigVault{},
+				Credential: config.ConfigTargetCredential{
+					Type: config.CredentialTypeDefault,
+				},
+			},
+		}
 
-	// init resource tag manager
-	AzureResourceTagManager, err = AzureClient.TagManager.ParseTagConfig(Opts.Azure.ResourceTags)
-	if err != nil {
-		logger.Fatalf(`unable to parse resourceTag configuration "%s": %v"`, Opts.Azure.ResourceTags, err.Error())
+		for _, vaultUrl := range Opts.KeyVault.Url {
+			targetConfigList[0].Vaults = append(targetConfigList[0].Vaults, config.ConfigVault{URL: vaultUrl})
+		}
+	}
+
+	for _, targetConfig := range targetConfigList {
+		logger.Infof(`init Azure target "%s"`, targetConfig.Name)
+		target, err := NewAzureTarget(targetConfig)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		AzureTargets = append(AzureTargets, target)
 	}
 }
 
 func initMetricCollector() {
-	collectorName := "keyvault"
-	if Opts.Scrape.Time.Seconds() > 0 {
-		c := collector.New(collectorName, &MetricsCollectorKeyvault{}, logger)
-		c.SetScapeTime(Opts.Scrape.Time)
+	// inventory (discovery), content and details are collected independently, KeyVault discovery results are shared
+	startMetricCollector("keyvault-inventory", &MetricsCollectorKeyvaultInventory{}, Opts.Scrape.TimeInventory)
+	startMetricCollector("keyvault", &MetricsCollectorKeyvault{}, Opts.Scrape.Time)
+	startMetricCollector("keyvault-details", &MetricsCollectorKeyvaultDetails{}, Opts.Scrape.TimeDetails)
+}
+
+func startMetricCollector(collectorName string, processor collector.ProcessorInterface, scrapeTime time.Duration) {
+	if scrapeTime.Seconds() > 0 {
+		exporterHealth.registerCollector(collectorName, scrapeTime)
+		metricsSinks.registerCollector(collectorName)
+
+		c := collector.New(collectorName, processor, logger)
+		c.SetScapeTime(scrapeTime)
 		c.SetConcurrency(Opts.Scrape.Concurrency)
-		c.SetCache(
-			Opts.GetCachePath(collectorName+".json"),
-			collector.BuildCacheTag(cacheTag, Opts.Azure, Opts.KeyVault),
-		)
+		c.SetCache(collectorCacheSpec(collectorName))
 		if err := c.Start(); err != nil {
 			logger.Fatal(err.Error())
 		}
@@ -116,12 +183,22 @@ func initMetricCollector() {
 	}
 }
 
+// collectorCacheSpec returns cache path and cache tag of collector
+func collectorCacheSpec(collectorName string) (*string, *string) {
+	return Opts.GetCachePath(collectorName + ".json"), collector.BuildCacheTag(cacheTag, Opts.Azure, Opts.KeyVault, AppConfig)
+}
+
 // start and handle prometheus handler
 func startHttpServer() {
 	mux := http.NewServeMux()
 
 	// healthz
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("verbose") {
+			handleStatus(w, r)
+			return
+		}
+
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
 			logger.Error(err)
 		}
@@ -129,13 +206,29 @@ func startHttpServer() {
 
 	// readyz
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if ready, reason := exporterHealth.Ready(); !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if _, err := fmt.Fprint(w, reason); err != nil {
+				logger.Error(err)
+			}
+			return
+		}
+
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
 			logger.Error(err)
 		}
 	})
 
+	// status
+	mux.HandleFunc("/status", handleStatus)
+
 	mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
 
+	// probe
+	if Opts.Probe.Enable {
+		mux.HandleFunc("/probe", handleProbe)
+	}
+
 	srv := &http.Server{
 		Addr:         Opts.Server.Bind,
 		Handler:      mux,
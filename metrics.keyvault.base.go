@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"github.com/webdevops/go-common/prometheus/collector"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type (
+	// MetricsCollectorKeyvaultBase contains the common parts of all KeyVault collectors (inventory, content, details)
+	MetricsCollectorKeyvaultBase struct {
+		collector.Processor
+
+		// metric lists used instead of collector metric lists (eg. for probe requests)
+		metricLists map[string]*prometheusCommon.MetricList
+
+		// gauge metrics (reset on each run, kept if this replica is not the leader)
+		gaugeVecs map[string]*prometheus.GaugeVec
+
+		// collector cache is disabled while this replica is not the leader
+		cacheSuspended bool
+
+		// results of current run (nil for probe requests)
+		run *keyvaultCollectorRun
+	}
+
+	// keyvaultCollectorRun counts the results of one collector run (readiness and cycle metrics)
+	keyvaultCollectorRun struct {
+		startTime     time.Time
+		targetErrors  atomic.Int64
+		vaultsSuccess atomic.Int64
+		vaultsFailed  atomic.Int64
+		vaultsSkipped atomic.Int64
+
+		// replica is not the leader, nothing was collected
+		standby bool
+	}
+
+	// keyvaultCollectFunc collects one KeyVault and returns if collection was successful
+	keyvaultCollectFunc func(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool
+)
+
+func (m *MetricsCollectorKeyvaultBase) Reset() {
+	if m.run != nil && m.run.standby {
+		// follower, last collected metrics are kept
+		return
+	}
+
+	for _, vec := range m.gaugeVecs {
+		vec.Reset()
+	}
+
+	if m.run == nil && m.metricLists == nil {
+		// reset without previous collect run, metrics were restored from cache
+		exporterHealth.collectorRestored(m.Collector.Name, m.Collector.GetLastScapeTime())
+		metricsSinks.collectorFinished(m.Collector.Name)
+	}
+}
+
+// runStart starts tracking of run results, has to be called at the start of Collect
+func (m *MetricsCollectorKeyvaultBase) runStart() {
+	m.run = &keyvaultCollectorRun{startTime: time.Now()}
+}
+
+// runFinish stores run results for readiness and cycle metrics and triggers metric pushes (OTLP, pushgateway, remote-write) and textfile writes,
+// has to be called after all KeyVaults are collected (callback)
+func (m *MetricsCollectorKeyvaultBase) runFinish() {
+	exporterHealth.collectorFinished(m.Collector.Name, m.run)
+	observeCollectorRun(m.Collector.Name, m.run)
+	otlpMetrics.Trigger()
+	metricsSinks.collectorFinished(m.Collector.Name)
+}
+
+// registerMetricList registers gauge metric list at collector (reset on each run by Reset, except on followers)
+func (m *MetricsCollectorKeyvaultBase) registerMetricList(name string, vec *prometheus.GaugeVec) {
+	m.Collector.RegisterMetricList(name, vec, false)
+
+	if m.gaugeVecs == nil {
+		m.gaugeVecs = map[string]*prometheus.GaugeVec{}
+	}
+	m.gaugeVecs[name] = vec
+}
+
+// standby returns true if this replica is not the leader, the currently exported metrics are kept instead of collecting
+// (no reset) and the collector cache is disabled while standby, so the cache of the last collection is not overwritten
+func (m *MetricsCollectorKeyvaultBase) standby() bool {
+	if leaderElection.IsLeader() {
+		if m.cacheSuspended {
+			m.Collector.SetCache(collectorCacheSpec(m.Collector.Name))
+			m.cacheSuspended = false
+		}
+		return false
+	}
+
+	m.Logger().Info("not leader, keeping last collected metrics")
+
+	if !m.cacheSuspended {
+		m.Collector.DisableCache()
+		m.cacheSuspended = true
+	}
+	m.run.standby = true
+	exporterHealth.collectorStandby(m.Collector.Name)
+
+	return true
+}
+
+// metricList returns metric list by name (from local metric lists if set or from collector)
+func (m *MetricsCollectorKeyvaultBase) metricList(name string) *prometheusCommon.MetricList {
+	if m.metricLists != nil {
+		return m.metricLists[name]
+	}
+
+	return m.Collector.GetMetricList(name).MetricList
+}
+
+// collectContext returns context with overall collection deadline (--scrape.timeout) and the trace span
+// of the collector run, cancel also ends the span
+func (m *MetricsCollectorKeyvaultBase) collectContext() (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(m.Context(), "Collect", trace.WithAttributes(attribute.String("collector", m.Collector.Name)))
+
+	if Opts.Scrape.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Opts.Scrape.Timeout)
+		return ctx, func() {
+			cancel()
+			span.End()
+		}
+	}
+
+	return ctx, func() {
+		span.End()
+	}
+}
+
+// collectKeyVaults starts collection of all KeyVaults of the shared inventory, refresh forces a new discovery,
+// KeyVaults are not collected in this run if skip returns true (eg. not due yet)
+func (m *MetricsCollectorKeyvaultBase) collectKeyVaults(ctx context.Context, refresh bool, skip func(target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool, collect keyvaultCollectFunc, finished func(target *AzureTarget, vault *KeyVault, success bool)) {
+	for _, target := range AzureTargets {
+		contextLogger := m.Logger().With(zap.String("target", target.Name))
+
+		var (
+			vaultList []*KeyVault
+			err       error
+		)
+		if refresh {
+			vaultList, err = keyvaultInventory.Refresh(ctx, target, contextLogger)
+		} else {
+			vaultList, err = keyvaultInventory.Vaults(ctx, target, contextLogger)
+		}
+		if err != nil {
+			m.collectError(CollectErrorScopeTarget, err)
+			m.run.targetError()
+			contextLogger.Error(err)
+		}
+
+		for _, vault := range vaultList {
+			if skip != nil && skip(target, vault, contextLogger) {
+				m.run.vaultSkipped()
+				continue
+			}
+
+			m.startKeyVaultCollection(ctx, target, vault, contextLogger, collect, finished)
+		}
+	}
+}
+
+// startKeyVaultCollection starts collection of KeyVault in background (limited by scrape concurrency)
+func (m *MetricsCollectorKeyvaultBase) startKeyVaultCollection(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger, collect keyvaultCollectFunc, finished func(target *AzureTarget, vault *KeyVault, success bool)) {
+	contextLogger := logger.With(
+		zap.String("keyvault", vault.Name),
+		zap.String("location", vault.Location),
+		zap.String("resourceGroup", vault.ResourceGroup),
+	)
+
+	m.WaitGroup().Add()
+	go func(vault *KeyVault, contextLogger *zap.SugaredLogger) {
+		defer m.WaitGroup().Done()
+
+		vaultCtx, span := tracer.Start(ctx, "collectKeyVault", trace.WithAttributes(
+			attribute.String("collector", m.Collector.Name),
+			attribute.String("target", target.Name),
+			attribute.String("keyvault", vault.Name),
+			attribute.String("resourceID", vault.ResourceID),
+		))
+		contextLogger = tracingLogger(vaultCtx, contextLogger)
+
+		success := false
+		defer func() {
+			// isolate KeyVault failures, other KeyVaults are still reported
+			if r := recover(); r != nil {
+				contextLogger.Error(m.collectPanic(CollectErrorScopeVault, r))
+			}
+
+			m.run.vaultFinished(success)
+
+			if !success {
+				span.SetStatus(codes.Error, "keyvault collection failed")
+			}
+			span.End()
+
+			if finished != nil {
+				finished(target, vault, success)
+			}
+		}()
+
+		if Opts.Scrape.VaultTimeout > 0 {
+			var cancel context.CancelFunc
+			vaultCtx, cancel = context.WithTimeout(vaultCtx, Opts.Scrape.VaultTimeout)
+			defer cancel()
+		}
+
+		contextLogger.Info("collecting keyvault metrics")
+		startTime := time.Now()
+		success = collect(vaultCtx, target, vault, contextLogger)
+		m.observeVaultDuration(InstrumentationScopeVault, time.Since(startTime))
+
+		if errors.Is(vaultCtx.Err(), context.DeadlineExceeded) {
+			contextLogger.Warn("keyvault collection timed out")
+		}
+	}(vault, contextLogger)
+}
+
+// targetError counts discovery error of run (nil safe, eg. for probe requests)
+func (r *keyvaultCollectorRun) targetError() {
+	if r != nil {
+		r.targetErrors.Add(1)
+	}
+}
+
+// vaultSkipped counts KeyVault which was not due in this run (nil safe)
+func (r *keyvaultCollectorRun) vaultSkipped() {
+	if r != nil {
+		r.vaultsSkipped.Add(1)
+	}
+}
+
+// vaultFinished counts KeyVault collection result of run (nil safe)
+func (r *keyvaultCollectorRun) vaultFinished(success bool) {
+	if r == nil {
+		return
+	}
+
+	if success {
+		r.vaultsSuccess.Add(1)
+	} else {
+		r.vaultsFailed.Add(1)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/collector"
+	"github.com/webdevops/go-common/utils/to"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// details of items which were not seen anymore are removed from cache after this duration
+	keyvaultDetailCacheRetention = 7 * 24 * time.Hour
+
+	keyvaultDetailCacheFile = "keyvault-details-items.json"
+)
+
+var (
+	keyvaultKeyDetailLabels = []string{
+		"keyType",
+		"keyCurve",
+		"keySize",
+		"keyOps",
+	}
+
+	keyvaultCertificateDetailLabels = []string{
+		"issuer",
+		"subject",
+		"keyType",
+		"keySize",
+		"autoRenew",
+	}
+)
+
+type (
+	// keyvaultDetailCache caches detail labels of items by item ID and updated timestamp
+	keyvaultDetailCache struct {
+		Items map[string]*keyvaultDetailCacheItem `json:"items"`
+
+		changed bool
+		lock    sync.Mutex
+		storage keyvaultDetailCacheStorage
+	}
+
+	keyvaultDetailCacheItem struct {
+		Updated  int64             `json:"updated"`
+		LastSeen int64             `json:"lastSeen"`
+		Labels   map[string]string `json:"labels"`
+	}
+
+	keyvaultDetailCacheStorage interface {
+		Load() ([]byte, error)
+		Store(content []byte) error
+		String() string
+	}
+
+	keyvaultDetailCacheFileStorage struct {
+		path string
+	}
+
+	keyvaultDetailCacheAzBlobStorage struct {
+		client    *azblob.Client
+		container string
+		blob      string
+	}
+)
+
+// MetricsCollectorKeyvaultDetails collects details of keys and certificates listed by the content collector, details are cached and only fetched for new or updated items
+type MetricsCollectorKeyvaultDetails struct {
+	MetricsCollectorKeyvaultBase
+
+	// cached content details (by item ID and updated timestamp)
+	detailCache *keyvaultDetailCache
+
+	// key and certificate listing of content collector
+	contentItems *keyvaultContentItemList
+
+	prometheus struct {
+		keyvaultKeyDetails         *prometheus.GaugeVec
+		keyvaultCertificateDetails *prometheus.GaugeVec
+
+		// detail cache
+		keyvaultDetailCacheHits   *prometheus.CounterVec
+		keyvaultDetailCacheMisses *prometheus.CounterVec
+	}
+}
+
+func (m *MetricsCollectorKeyvaultDetails) Setup(collector *collector.Collector) {
+	m.Processor.Setup(collector)
+
+	m.initMetrics(m.registerMetricList)
+
+	m.initDetailCache()
+
+	m.contentItems = keyvaultContentItems
+}
+
+// initMetrics creates all metric vecs and registers them using register func
+func (m *MetricsCollectorKeyvaultDetails) initMetrics(register func(name string, vec *prometheus.GaugeVec)) {
+	m.prometheus.keyvaultKeyDetails = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_key_details",
+			Help: "Azure KeyVault key details",
+		},
+		append(
+			[]string{
+				"target",
+				"tenantID",
+				"resourceID",
+				"vaultName",
+				"keyID",
+			},
+			keyvaultKeyDetailLabels...,
+		),
+	)
+	register("keyvaultKeyDetails", m.prometheus.keyvaultKeyDetails)
+
+	m.prometheus.keyvaultCertificateDetails = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_certificate_details",
+			Help: "Azure KeyVault certificate details",
+		},
+		append(
+			[]string{
+				"target",
+				"tenantID",
+				"resourceID",
+				"vaultName",
+				"certificateID",
+			},
+			keyvaultCertificateDetailLabels...,
+		),
+	)
+	register("keyvaultCertificateDetails", m.prometheus.keyvaultCertificateDetails)
+}
+
+// initDetailCache creates detail cache (restored from --cache.path if set) and cache metrics
+func (m *MetricsCollectorKeyvaultDetails) initDetailCache() {
+	m.prometheus.keyvaultDetailCacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_detail_cache_hits_total",
+			Help: "Azure KeyVault content detail cache hits (item not changed, details not fetched)",
+		},
+		[]string{"type"},
+	)
+	m.Collector.RegisterMetricList("keyvaultDetailCacheHits", m.prometheus.keyvaultDetailCacheHits, false)
+
+	m.prometheus.keyvaultDetailCacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_detail_cache_misses_total",
+			Help: "Azure KeyVault content detail cache misses (item new or changed, details fetched)",
+		},
+		[]string{"type"},
+	)
+	m.Collector.RegisterMetricList("keyvaultDetailCacheMisses", m.prometheus.keyvaultDetailCacheMisses, false)
+
+	m.detailCache = &keyvaultDetailCache{
+		Items: map[string]*keyvaultDetailCacheItem{},
+	}
+
+	if cachePath := Opts.GetCachePath(keyvaultDetailCacheFile); cachePath != nil {
+		storage, err := newKeyvaultDetailCacheStorage(*cachePath)
+		if err != nil {
+			m.Logger().Warnf(`detail cache is not persisted: %v`, err)
+			return
+		}
+		m.detailCache.storage = storage
+
+		if err := m.detailCache.Load(); err != nil {
+			m.Logger().Warnf(`unable to restore detail cache from %s: %v`, storage.String(), err)
+		} else {
+			m.Logger().Infof(`restored detail cache from %s (%v items)`, storage.String(), len(m.detailCache.Items))
+		}
+	}
+}
+
+func (m *MetricsCollectorKeyvaultDetails) Collect(callback chan<- func()) {
+	m.runStart()
+	if m.standby() {
+		return
+	}
+
+	ctx, cancel := m.collectContext()
+
+	m.collectKeyVaults(ctx, false, m.contentItemsSkip, m.collectKeyVaultDetails, nil)
+
+	// executed after all KeyVaults are collected
+	callback <- func() {
+		cancel()
+		m.runFinish()
+		m.saveDetailCache()
+		m.contentItems.cleanup()
+	}
+}
+
+// contentItemsSkip returns true if content of KeyVault was not listed by content collector yet
+func (m *MetricsCollectorKeyvaultDetails) contentItemsSkip(target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool {
+	if _, _, exists := m.contentItems.Get(target, vault); !exists {
+		logger.With(zap.String("keyvault", vault.Name)).Debug(`keyvault content not listed yet, skipping details`)
+		return true
+	}
+	return false
+}
+
+// collectKeyVaultDetails collects details of keys and certificates of KeyVault (listing is taken from content collector)
+func (m *MetricsCollectorKeyvaultDetails) collectKeyVaultDetails(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) (status bool) {
+	status = true
+
+	keyItems, certificateItems, _ := m.contentItems.Get(target, vault)
+
+	// ########################
+	// Keys
+	// ########################
+
+	keyOpts := azkeys.ClientOptions{
+		ClientOptions: target.NewAzCoreClientOptions(),
+	}
+	keyClient, err := azkeys.NewClient(vault.URL, target.GetCred(), &keyOpts)
+	if err != nil {
+		m.collectError(CollectErrorScopeVault, err)
+		logger.Error(err)
+		return false
+	}
+
+	keyDetailMetrics := m.metricList("keyvaultKeyDetails")
+	keyCtx, keySpan := tracer.Start(ctx, "collectKeyVault."+CollectErrorScopeKeys, trace.WithAttributes(attribute.String("scope", CollectErrorScopeKeys)))
+	startTime := time.Now()
+	var keyErr error
+	for _, item := range keyItems {
+		if item.KID == nil || item.Attributes == nil {
+			continue
+		}
+
+		itemID := string(*item.KID)
+		details, err := m.itemDetails("keys", itemID, item.Attributes.Updated, func() (map[string]string, error) {
+			return fetchKeyDetails(keyCtx, keyClient, item)
+		})
+		if err != nil {
+			keyErr = err
+			m.collectError(CollectErrorScopeKeys, err)
+			logger.Warnf(`unable to fetch details of key "%s": %v`, item.KID.Name(), err)
+			status = false
+			continue
+		}
+
+		detailLabels := prometheus.Labels{
+			"target":     target.Name,
+			"tenantID":   vault.TenantID,
+			"resourceID": vault.ResourceID,
+			"vaultName":  vault.Name,
+			"keyID":      itemID,
+		}
+		for name, value := range details {
+			detailLabels[name] = value
+		}
+		keyDetailMetrics.AddInfo(detailLabels)
+	}
+	m.observeVaultDuration(CollectErrorScopeKeys, time.Since(startTime))
+	tracingEnd(keySpan, keyErr)
+
+	// ########################
+	// Certificates
+	// ########################
+
+	certificateOpts := azcertificates.ClientOptions{
+		ClientOptions: target.NewAzCoreClientOptions(),
+	}
+	certificateClient, err := azcertificates.NewClient(vault.URL, target.GetCred(), &certificateOpts)
+	if err != nil {
+		m.collectError(CollectErrorScopeVault, err)
+		logger.Error(err)
+		return false
+	}
+
+	certificateDetailMetrics := m.metricList("keyvaultCertificateDetails")
+	certificateCtx, certificateSpan := tracer.Start(ctx, "collectKeyVault."+CollectErrorScopeCertificates, trace.WithAttributes(attribute.String("scope", CollectErrorScopeCertificates)))
+	startTime = time.Now()
+	var certificateErr error
+	for _, item := range certificateItems {
+		if item.ID == nil || item.Attributes == nil {
+			continue
+		}
+
+		itemID := string(*item.ID)
+		details, err := m.itemDetails("certificates", itemID, item.Attributes.Updated, func() (map[string]string, error) {
+			return fetchCertificateDetails(certificateCtx, certificateClient, item)
+		})
+		if err != nil {
+			certificateErr = err
+			m.collectError(CollectErrorScopeCertificates, err)
+			logger.Warnf(`unable to fetch details of certificate "%s": %v`, item.ID.Name(), err)
+			status = false
+			continue
+		}
+
+		detailLabels := prometheus.Labels{
+			"target":        target.Name,
+			"tenantID":      vault.TenantID,
+			"resourceID":    vault.ResourceID,
+			"vaultName":     vault.Name,
+			"certificateID": itemID,
+		}
+		for name, value := range details {
+			detailLabels[name] = value
+		}
+		certificateDetailMetrics.AddInfo(detailLabels)
+	}
+	m.observeVaultDuration(CollectErrorScopeCertificates, time.Since(startTime))
+	tracingEnd(certificateSpan, certificateErr)
+
+	return
+}
+
+// itemDetails returns details of item from cache or fetches them if item is new or changed
+func (m *MetricsCollectorKeyvaultDetails) itemDetails(itemType, itemID string, updated *time.Time, fetch func() (map[string]string, error)) (map[string]string, error) {
+	if m.detailCache == nil {
+		// probe request
+		return fetch()
+	}
+
+	if labels, exists := m.detailCache.Get(itemID, updated); exists {
+		m.metricList("keyvaultDetailCacheHits").Add(prometheus.Labels{"type": itemType}, 1)
+		return labels, nil
+	}
+
+	m.metricList("keyvaultDetailCacheMisses").Add(prometheus.Labels{"type": itemType}, 1)
+	labels, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	m.detailCache.Set(itemID, updated, labels)
+	return labels, nil
+}
+
+// saveDetailCache persists detail cache (if changed)
+func (m *MetricsCollectorKeyvaultDetails) saveDetailCache() {
+	if m.detailCache == nil {
+		return
+	}
+
+	if err := m.detailCache.Save(); err != nil {
+		m.Logger().Warnf(`unable to save detail cache: %v`, err)
+	}
+}
+
+// fetchKeyDetails fetches key details (key type, curve, size and operations)
+func fetchKeyDetails(ctx context.Context, client *azkeys.Client, item *azkeys.KeyProperties) (map[string]string, error) {
+	ret := emptyDetailLabels(keyvaultKeyDetailLabels)
+
+	result, err := client.GetKey(ctx, item.KID.Name(), item.KID.Version(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := result.Key; key != nil {
+		if key.Kty != nil {
+			ret["keyType"] = string(*key.Kty)
+		}
+
+		if key.Crv != nil {
+			ret["keyCurve"] = string(*key.Crv)
+		}
+
+		if len(key.N) > 0 {
+			ret["keySize"] = strconv.Itoa(len(key.N) * 8)
+		}
+
+		keyOps := []string{}
+		for _, keyOp := range key.KeyOps {
+			if keyOp != nil {
+				keyOps = append(keyOps, string(*keyOp))
+			}
+		}
+		sort.Strings(keyOps)
+		ret["keyOps"] = strings.Join(keyOps, ",")
+	}
+
+	return ret, nil
+}
+
+// fetchCertificateDetails fetches certificate details from certificate policy (issuer, subject, key and auto renew)
+func fetchCertificateDetails(ctx context.Context, client *azcertificates.Client, item *azcertificates.CertificateProperties) (map[string]string, error) {
+	ret := emptyDetailLabels(keyvaultCertificateDetailLabels)
+
+	result, err := client.GetCertificatePolicy(ctx, item.ID.Name(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.IssuerParameters != nil {
+		ret["issuer"] = to.String(result.IssuerParameters.Name)
+	}
+
+	if result.X509CertificateProperties != nil {
+		ret["subject"] = to.String(result.X509CertificateProperties.Subject)
+	}
+
+	if result.KeyProperties != nil {
+		if result.KeyProperties.KeyType != nil {
+			ret["keyType"] = string(*result.KeyProperties.KeyType)
+		}
+
+		if result.KeyProperties.KeySize != nil {
+			ret["keySize"] = strconv.Itoa(int(*result.KeyProperties.KeySize))
+		}
+	}
+
+	autoRenew := false
+	for _, action := range result.LifetimeActions {
+		if action != nil && action.Action != nil && action.Action.ActionType != nil && *action.Action.ActionType == azcertificates.CertificatePolicyActionAutoRenew {
+			autoRenew = true
+		}
+	}
+	ret["autoRenew"] = to.BoolString(autoRenew)
+
+	return ret, nil
+}
+
+// emptyDetailLabels returns labels with empty values (all detail labels have to be set)
+func emptyDetailLabels(labels []string) map[string]string {
+	ret := map[string]string{}
+	for _, name := range labels {
+		ret[name] = ""
+	}
+	return ret
+}
+
+// Get returns cached detail labels if item was not updated
+func (c *keyvaultDetailCache) Get(itemID string, updated *time.Time) (map[string]string, bool) {
+	if updated == nil {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if item, exists := c.Items[itemID]; exists && item.Updated == updated.Unix() {
+		item.LastSeen = time.Now().Unix()
+		return item.Labels, true
+	}
+
+	return nil, false
+}
+
+// Set stores detail labels of item
+func (c *keyvaultDetailCache) Set(itemID string, updated *time.Time, labels map[string]string) {
+	if updated == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Items[itemID] = &keyvaultDetailCacheItem{
+		Updated:  updated.Unix(),
+		LastSeen: time.Now().Unix(),
+		Labels:   labels,
+	}
+	c.changed = true
+}
+
+// Load restores cache from storage
+func (c *keyvaultDetailCache) Load() error {
+	if c.storage == nil {
+		return nil
+	}
+
+	content, err := c.storage.Load()
+	if err != nil || content == nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := json.Unmarshal(content, c); err != nil {
+		return err
+	}
+
+	if c.Items == nil {
+		c.Items = map[string]*keyvaultDetailCacheItem{}
+	}
+
+	return nil
+}
+
+// Save removes expired items and persists cache to storage (if changed)
+func (c *keyvaultDetailCache) Save() error {
+	c.lock.Lock()
+	for itemID, item := range c.Items {
+		if time.Since(time.Unix(item.LastSeen, 0)) > keyvaultDetailCacheRetention {
+			delete(c.Items, itemID)
+			c.changed = true
+		}
+	}
+
+	if c.storage == nil || !c.changed {
+		c.lock.Unlock()
+		return nil
+	}
+
+	content, err := json.Marshal(c)
+	c.changed = false
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.storage.Store(content)
+}
+
+// newKeyvaultDetailCacheStorage creates cache storage for path (file or azblob, like collector cache)
+func newKeyvaultDetailCacheStorage(path string) (keyvaultDetailCacheStorage, error) {
+	switch {
+	case strings.HasPrefix(path, "azblob://"):
+		parsedUrl, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pathParts := strings.SplitN(strings.TrimPrefix(parsedUrl.Path, "/"), "/", 2)
+		if len(pathParts) < 2 {
+			return nil, fmt.Errorf(`azblob path needs to be specified as azblob://storageaccount.blob.core.windows.net/container/blob, got: %v`, path)
+		}
+
+		client, err := newAzBlobClient(parsedUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		return &keyvaultDetailCacheAzBlobStorage{
+			client:    client,
+			container: pathParts[0],
+			blob:      pathParts[1],
+		}, nil
+	default:
+		return &keyvaultDetailCacheFileStorage{path: strings.TrimPrefix(path, "file://")}, nil
+	}
+}
+
+func (s *keyvaultDetailCacheFileStorage) Load() ([]byte, error) {
+	content, err := os.ReadFile(s.path) // #nosec inside container
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+func (s *keyvaultDetailCacheFileStorage) Store(content []byte) error {
+	dirPath := filepath.Dir(s.path)
+	if err := os.MkdirAll(dirPath, 0700); err != nil {
+		return err
+	}
+
+	// write to temp file first and rename (atomic operation)
+	tmpFilePath := filepath.Join(dirPath, fmt.Sprintf(".%s.tmp", filepath.Base(s.path)))
+	if err := os.WriteFile(tmpFilePath, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilePath, s.path)
+}
+
+func (s *keyvaultDetailCacheFileStorage) String() string {
+	return s.path
+}
+
+func (s *keyvaultDetailCacheAzBlobStorage) Load() ([]byte, error) {
+	response, err := s.client.DownloadStream(context.Background(), s.container, s.blob, nil)
+	if err != nil {
+		// cache not found or not accessible, start with empty cache
+		logger.With(zap.String("cache", s.String())).Debugf(`unable to download detail cache: %v`, err)
+		return nil, nil
+	}
+	defer response.Body.Close() // nolint: errcheck
+
+	return io.ReadAll(response.Body)
+}
+
+func (s *keyvaultDetailCacheAzBlobStorage) Store(content []byte) error {
+	_, err := s.client.UploadBuffer(context.Background(), s.container, s.blob, content, nil)
+	return err
+}
+
+func (s *keyvaultDetailCacheAzBlobStorage) String() string {
+	return fmt.Sprintf(`azblob://%s/%s`, s.container, s.blob)
+}
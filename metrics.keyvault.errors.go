@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	CollectErrorScopeTarget       = "target"
+	CollectErrorScopeSubscription = "subscription"
+	CollectErrorScopeVault        = "vault"
+	CollectErrorScopeKeys         = "keys"
+	CollectErrorScopeSecrets      = "secrets"
+	CollectErrorScopeCertificates = "certificates"
+
+	CollectErrorReasonUnauthorized          = "unauthorized"
+	CollectErrorReasonForbidden             = "forbidden"
+	CollectErrorReasonForbiddenRbac         = "forbidden_rbac"
+	CollectErrorReasonForbiddenAccessPolicy = "forbidden_accesspolicy"
+	CollectErrorReasonForbiddenFirewall     = "forbidden_firewall"
+	CollectErrorReasonNotFound              = "not_found"
+	CollectErrorReasonThrottled             = "throttled"
+	CollectErrorReasonDnsFailure            = "dns_failure"
+	CollectErrorReasonServerError           = "server_error"
+	CollectErrorReasonTimeout               = "timeout"
+	CollectErrorReasonCanceled              = "canceled"
+	CollectErrorReasonPanic                 = "panic"
+	CollectErrorReasonOther                 = "other"
+
+	// last success timestamps of KeyVaults which were not discovered anymore are removed after this duration
+	keyvaultLastSuccessRetention = 24 * time.Hour
+)
+
+type (
+	keyvaultLastSuccess struct {
+		labels      prometheus.Labels
+		lastSuccess time.Time
+		lastSeen    time.Time
+	}
+
+	keyvaultLastSuccessList struct {
+		list map[string]*keyvaultLastSuccess
+		lock sync.Mutex
+	}
+)
+
+var (
+	prometheusCollectErrors *prometheus.CounterVec
+)
+
+func init() {
+	// shared by all collectors (inventory, content, details)
+	prometheusCollectErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_keyvault_collect_errors_total",
+			Help: "Azure KeyVault collection errors",
+		},
+		[]string{
+			"scope",
+			"reason",
+		},
+	)
+	prometheus.MustRegister(prometheusCollectErrors)
+}
+
+// initLastSuccessMetrics creates last success metrics (not available for probe requests)
+func (m *MetricsCollectorKeyvault) initLastSuccessMetrics() {
+	m.lastSuccess = &keyvaultLastSuccessList{
+		list: map[string]*keyvaultLastSuccess{},
+	}
+
+	m.prometheus.keyvaultLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_last_success_timestamp",
+			Help: "Azure KeyVault timestamp of last successful collection",
+		},
+		[]string{
+			"target",
+			"resourceID",
+			"vaultName",
+		},
+	)
+	m.registerMetricList("keyvaultLastSuccess", m.prometheus.keyvaultLastSuccess)
+}
+
+// countCollectError counts collection error for scope, reason is detected from error
+func countCollectError(scope string, err error) {
+	prometheusCollectErrors.WithLabelValues(scope, collectErrorReason(err)).Inc()
+}
+
+// collectError counts collection error for scope (not for probe requests)
+func (m *MetricsCollectorKeyvaultBase) collectError(scope string, err error) {
+	if m.metricLists != nil {
+		// probe request, errors are reported by probe success metric
+		return
+	}
+
+	countCollectError(scope, err)
+}
+
+// collectPanic converts recovered panic to error and counts it
+func (m *MetricsCollectorKeyvaultBase) collectPanic(scope string, r interface{}) error {
+	err := &collectPanicError{value: r}
+	m.collectError(scope, err)
+	return err
+}
+
+// keyvaultSeen marks KeyVault as discovered and updates last success timestamp if collection was successful
+func (m *MetricsCollectorKeyvault) keyvaultSeen(target *AzureTarget, vault *KeyVault, success bool) {
+	if m.lastSuccess == nil {
+		return
+	}
+
+	m.lastSuccess.lock.Lock()
+	defer m.lastSuccess.lock.Unlock()
+
+	key := target.Name + ":" + vault.ResourceID
+	entry, exists := m.lastSuccess.list[key]
+	if !exists {
+		entry = &keyvaultLastSuccess{
+			labels: prometheus.Labels{
+				"target":     target.Name,
+				"resourceID": vault.ResourceID,
+				"vaultName":  vault.Name,
+			},
+		}
+		m.lastSuccess.list[key] = entry
+	}
+
+	entry.lastSeen = time.Now()
+	if success {
+		entry.lastSuccess = entry.lastSeen
+	}
+}
+
+// collectLastSuccess writes last success timestamps of all known KeyVaults (also failed ones) to metric list
+func (m *MetricsCollectorKeyvault) collectLastSuccess() {
+	if m.lastSuccess == nil {
+		return
+	}
+
+	m.lastSuccess.lock.Lock()
+	defer m.lastSuccess.lock.Unlock()
+
+	metricList := m.metricList("keyvaultLastSuccess")
+	for key, entry := range m.lastSuccess.list {
+		if time.Since(entry.lastSeen) > keyvaultLastSuccessRetention {
+			delete(m.lastSuccess.list, key)
+			continue
+		}
+
+		if !entry.lastSuccess.IsZero() {
+			metricList.Add(entry.labels, float64(entry.lastSuccess.Unix()))
+		}
+	}
+}
+
+// collectErrorReason returns reason label for error
+func collectErrorReason(err error) string {
+	reason, _ := classifyError(err)
+	return reason
+}
+
+// classifyError returns reason and http status code (empty if no response was received) for error
+func classifyError(err error) (reason string, statusCode string) {
+	var panicErr *collectPanicError
+	if errors.As(err, &panicErr) {
+		return CollectErrorReasonPanic, ""
+	}
+
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		statusCode = strconv.Itoa(responseErr.StatusCode)
+
+		switch {
+		case responseErr.StatusCode == http.StatusUnauthorized:
+			return CollectErrorReasonUnauthorized, statusCode
+		case responseErr.StatusCode == http.StatusForbidden:
+			return classifyForbiddenError(responseErr), statusCode
+		case responseErr.StatusCode == http.StatusNotFound:
+			return CollectErrorReasonNotFound, statusCode
+		case responseErr.StatusCode == http.StatusTooManyRequests:
+			return CollectErrorReasonThrottled, statusCode
+		case responseErr.StatusCode == http.StatusRequestTimeout, responseErr.StatusCode == http.StatusGatewayTimeout:
+			return CollectErrorReasonTimeout, statusCode
+		case responseErr.StatusCode >= 500:
+			return CollectErrorReasonServerError, statusCode
+		}
+
+		return CollectErrorReasonOther, statusCode
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return CollectErrorReasonTimeout, ""
+		}
+		return CollectErrorReasonDnsFailure, ""
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CollectErrorReasonTimeout, ""
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return CollectErrorReasonTimeout, ""
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return CollectErrorReasonCanceled, ""
+	}
+
+	return CollectErrorReasonOther, ""
+}
+
+// classifyForbiddenError detects why KeyVault access was denied (firewall, RBAC or access policy) using the error response
+//
+//	{"error":{"code":"Forbidden","message":"...","innererror":{"code":"ForbiddenByFirewall"}}}
+func classifyForbiddenError(responseErr *azcore.ResponseError) string {
+	errorCode := responseErr.ErrorCode
+	innerErrorCode := ""
+	message := ""
+
+	if responseErr.RawResponse != nil {
+		// body is cached by azcore and can be read again
+		if body, err := runtime.Payload(responseErr.RawResponse); err == nil {
+			errorResponse := struct {
+				Error struct {
+					Code       string `json:"code"`
+					Message    string `json:"message"`
+					InnerError struct {
+						Code string `json:"code"`
+					} `json:"innererror"`
+				} `json:"error"`
+			}{}
+
+			if err := json.Unmarshal(body, &errorResponse); err == nil {
+				if errorResponse.Error.Code != "" {
+					errorCode = errorResponse.Error.Code
+				}
+				innerErrorCode = errorResponse.Error.InnerError.Code
+				message = errorResponse.Error.Message
+			}
+		}
+	}
+
+	switch {
+	case strings.EqualFold(innerErrorCode, "ForbiddenByFirewall"), strings.EqualFold(errorCode, "ForbiddenByFirewall"):
+		return CollectErrorReasonForbiddenFirewall
+	case strings.EqualFold(innerErrorCode, "ForbiddenByRbac"), strings.EqualFold(errorCode, "ForbiddenByRbac"):
+		return CollectErrorReasonForbiddenRbac
+	case strings.EqualFold(innerErrorCode, "AccessDenied"), strings.Contains(strings.ToLower(message), "access policy"):
+		return CollectErrorReasonForbiddenAccessPolicy
+	}
+
+	return CollectErrorReasonForbidden
+}
+
+// keyvaultAccessStatusLabels returns labels for access status metric of scope (keys, secrets, certificates)
+func keyvaultAccessStatusLabels(vault *KeyVault, scope string, err error) prometheus.Labels {
+	labels := prometheus.Labels{
+		"resourceID": vault.ResourceID,
+		"vaultName":  vault.Name,
+		"type":       "access",
+		"scope":      scope,
+		"reason":     "",
+		"statusCode": "",
+	}
+
+	if err != nil {
+		labels["reason"], labels["statusCode"] = classifyError(err)
+	}
+
+	return labels
+}
+
+type collectPanicError struct {
+	value interface{}
+}
+
+func (e *collectPanicError) Error() string {
+	return fmt.Sprintf(`panic: %v`, e.value)
+}
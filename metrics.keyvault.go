@@ -2,37 +2,56 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"regexp"
-	"strings"
+	"sync"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/remeh/sizedwaitgroup"
 	"github.com/webdevops/go-common/prometheus/collector"
 	"github.com/webdevops/go-common/utils/to"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-var (
-	azureTagNameToPrometheusNameRegExp = regexp.MustCompile("[^_a-zA-Z0-9]")
-)
-
+// MetricsCollectorKeyvault collects the content (keys, secrets, certificates) of all KeyVaults
 type MetricsCollectorKeyvault struct {
-	collector.Processor
+	MetricsCollectorKeyvaultBase
+
+	contentTagManager *ContentTagManager
+
+	// last success timestamps of KeyVaults (kept across collection runs)
+	lastSuccess *keyvaultLastSuccessList
+
+	// last known good content data of KeyVaults (kept across collection runs)
+	snapshots *keyvaultSnapshotList
+
+	// next collection of KeyVaults based on nearest upcoming expiry
+	schedule *keyvaultSchedule
+
+	// key and certificate listing for details collector (nil if details collector is disabled)
+	contentItems *keyvaultContentItemList
 
-	contentTagManager ContentTagManager
+	// KeyVault metadata is collected with content if inventory collector is disabled
+	inventory *MetricsCollectorKeyvaultInventory
 
 	prometheus struct {
 		// general
-		keyvault             *prometheus.GaugeVec
-		keyvaultStatus       *prometheus.GaugeVec
-		keyvaultAccessPolicy *prometheus.GaugeVec
-		keyvaultEntryCount   *prometheus.GaugeVec
+		keyvaultStatus          *prometheus.GaugeVec
+		keyvaultAccessPolicy    *prometheus.GaugeVec
+		keyvaultEntryCount      *prometheus.GaugeVec
+		keyvaultContentTag      *prometheus.GaugeVec
+		keyvaultDataAge         *prometheus.GaugeVec
+		keyvaultCollectDuration *prometheus.GaugeVec
+
+		// errors
+		keyvaultLastSuccess *prometheus.GaugeVec
+
+		// schedule
+		keyvaultCollectInterval *prometheus.GaugeVec
 
 		// key
 		keyvaultKeyInfo   *prometheus.GaugeVec
@@ -48,85 +67,50 @@ type MetricsCollectorKeyvault struct {
 	}
 }
 
-type (
-	ContentTagManager struct {
-		config []ContentTagConfig
-	}
+func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
+	m.Processor.Setup(collector)
 
-	ContentTagConfig struct {
-		Label string
-		Tag   string
+	if err := m.initContentTagManager(); err != nil {
+		m.Logger().Fatalf(`unable to parse content tag configuration: %v`, err.Error())
 	}
-)
 
-// AddTag adds tag to configuration
-func (ctm *ContentTagManager) AddTag(tagName string) {
-	labelName := fmt.Sprintf(
-		"tag_%s",
-		azureTagNameToPrometheusNameRegExp.ReplaceAllLiteralString(strings.ToLower(tagName), "_"),
-	)
+	m.initMetrics(m.registerMetricList)
 
-	ctm.config = append(
-		ctm.config,
-		ContentTagConfig{
-			Tag:   tagName,
-			Label: labelName,
-		},
-	)
-}
-
-// AddContentTags adds content tags to prometheus labels for metric
-func (ctm *ContentTagManager) AddContentTags(labels prometheus.Labels, tags map[string]*string) prometheus.Labels {
-	for _, row := range ctm.config {
-		// default value
-		labels[row.Label] = ""
-
-		if val, exists := tags[row.Tag]; exists {
-			labels[row.Label] = to.String(val)
-		}
+	if Opts.Scrape.TimeInventory <= 0 {
+		m.inventory = &MetricsCollectorKeyvaultInventory{MetricsCollectorKeyvaultBase: m.MetricsCollectorKeyvaultBase}
+		m.inventory.initMetrics(m.registerMetricList)
 	}
 
-	return labels
-}
-
-// AddToPrometheusLabels adds prometheus labels for metric definition
-func (ctm *ContentTagManager) AddToPrometheusLabels(val []string) []string {
-	for _, row := range ctm.config {
-		val = append(val, row.Label)
+	if Opts.Scrape.TimeDetails > 0 {
+		m.contentItems = keyvaultContentItems
 	}
 
-	return val
-}
-
-func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
-	m.Processor.Setup(collector)
+	m.initLastSuccessMetrics()
 
-	m.contentTagManager = ContentTagManager{
-		config: []ContentTagConfig{},
+	m.snapshots = &keyvaultSnapshotList{
+		list: map[string]*keyvaultSnapshot{},
 	}
 
-	for _, tagName := range Opts.KeyVault.Content.Tags {
-		m.contentTagManager.AddTag(tagName)
-	}
+	m.initSchedule()
+}
 
-	m.prometheus.keyvault = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "azurerm_keyvault_info",
-			Help: "Azure KeyVault information",
-		},
-		AzureResourceTagManager.AddToPrometheusLabels(
-			[]string{
-				"subscriptionID",
-				"subscriptionName",
-				"resourceID",
-				"vaultName",
-				"location",
-				"resourceGroup",
-			},
-		),
+// initContentTagManager creates content tag manager from args
+func (m *MetricsCollectorKeyvault) initContentTagManager() error {
+	contentTagManager, err := NewContentTagManager(
+		Opts.KeyVault.Content.Tags,
+		Opts.KeyVault.Content.TagSource,
+		Opts.KeyVault.Content.TagDefault,
+		Opts.KeyVault.Content.TagStatus,
 	)
-	m.Collector.RegisterMetricList("keyvault", m.prometheus.keyvault, true)
+	if err != nil {
+		return err
+	}
+	m.contentTagManager = contentTagManager
+	return nil
+}
 
+// initMetrics creates all metric vecs and registers them using register func
+func (m *MetricsCollectorKeyvault) initMetrics(register func(name string, vec *prometheus.GaugeVec)) {
 	m.prometheus.keyvaultStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "azurerm_keyvault_status",
@@ -137,9 +121,11 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 			"vaultName",
 			"type",
 			"scope",
+			"reason",
+			"statusCode",
 		},
 	)
-	m.Collector.RegisterMetricList("keyvaultStatus", m.prometheus.keyvaultStatus, true)
+	register("keyvaultStatus", m.prometheus.keyvaultStatus)
 
 	m.prometheus.keyvaultEntryCount = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -152,7 +138,49 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 			"type",
 		},
 	)
-	m.Collector.RegisterMetricList("keyvaultEntryCount", m.prometheus.keyvaultEntryCount, true)
+	register("keyvaultEntryCount", m.prometheus.keyvaultEntryCount)
+
+	m.prometheus.keyvaultContentTag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_content_tag",
+			Help: "Azure KeyVault content (secret, key, certificate) tags matched by wildcard content tag config",
+		},
+		[]string{
+			"resourceID",
+			"vaultName",
+			"type",
+			"itemID",
+			"tag",
+			"value",
+		},
+	)
+	register("keyvaultContentTag", m.prometheus.keyvaultContentTag)
+
+	m.prometheus.keyvaultDataAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_data_age_seconds",
+			Help: "Azure KeyVault age of content data (0 if fresh, last known good data is used on failures)",
+		},
+		[]string{
+			"resourceID",
+			"vaultName",
+			"scope",
+		},
+	)
+	register("keyvaultDataAge", m.prometheus.keyvaultDataAge)
+
+	m.prometheus.keyvaultCollectDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_collect_duration_seconds",
+			Help: "Azure KeyVault duration of last content collection per scope",
+		},
+		[]string{
+			"resourceID",
+			"vaultName",
+			"scope",
+		},
+	)
+	register("keyvaultCollectDuration", m.prometheus.keyvaultCollectDuration)
 
 	// ------------------------------------------
 	// key
@@ -163,6 +191,8 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 		},
 		m.contentTagManager.AddToPrometheusLabels(
 			[]string{
+				"target",
+				"tenantID",
 				"resourceID",
 				"vaultName",
 				"keyName",
@@ -171,21 +201,25 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 			},
 		),
 	)
-	m.Collector.RegisterMetricList("keyvaultKeyInfo", m.prometheus.keyvaultKeyInfo, true)
+	register("keyvaultKeyInfo", m.prometheus.keyvaultKeyInfo)
 
 	m.prometheus.keyvaultKeyStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "azurerm_keyvault_key_status",
 			Help: "Azure KeyVault key status",
 		},
-		[]string{
-			"resourceID",
-			"vaultName",
-			"keyID",
-			"type",
-		},
+		m.contentTagManager.AddToPrometheusStatusLabels(
+			[]string{
+				"target",
+				"tenantID",
+				"resourceID",
+				"vaultName",
+				"keyID",
+				"type",
+			},
+		),
 	)
-	m.Collector.RegisterMetricList("keyvaultKeyStatus", m.prometheus.keyvaultKeyStatus, true)
+	register("keyvaultKeyStatus", m.prometheus.keyvaultKeyStatus)
 
 	// ------------------------------------------
 	// secret
@@ -196,6 +230,8 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 		},
 		m.contentTagManager.AddToPrometheusLabels(
 			[]string{
+				"target",
+				"tenantID",
 				"resourceID",
 				"vaultName",
 				"secretName",
@@ -204,21 +240,25 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 			},
 		),
 	)
-	m.Collector.RegisterMetricList("keyvaultSecretInfo", m.prometheus.keyvaultSecretInfo, true)
+	register("keyvaultSecretInfo", m.prometheus.keyvaultSecretInfo)
 
 	m.prometheus.keyvaultSecretStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "azurerm_keyvault_secret_status",
 			Help: "Azure KeyVault secret status",
 		},
-		[]string{
-			"resourceID",
-			"vaultName",
-			"secretID",
-			"type",
-		},
+		m.contentTagManager.AddToPrometheusStatusLabels(
+			[]string{
+				"target",
+				"tenantID",
+				"resourceID",
+				"vaultName",
+				"secretID",
+				"type",
+			},
+		),
 	)
-	m.Collector.RegisterMetricList("keyvaultSecretStatus", m.prometheus.keyvaultSecretStatus, true)
+	register("keyvaultSecretStatus", m.prometheus.keyvaultSecretStatus)
 
 	// ------------------------------------------
 	// certificate
@@ -229,6 +269,8 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 		},
 		m.contentTagManager.AddToPrometheusLabels(
 			[]string{
+				"target",
+				"tenantID",
 				"resourceID",
 				"vaultName",
 				"certificateName",
@@ -237,177 +279,187 @@ func (m *MetricsCollectorKeyvault) Setup(collector *collector.Collector) {
 			},
 		),
 	)
-	m.Collector.RegisterMetricList("keyvaultCertificateInfo", m.prometheus.keyvaultCertificateInfo, true)
+	register("keyvaultCertificateInfo", m.prometheus.keyvaultCertificateInfo)
 
 	m.prometheus.keyvaultCertificateStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "azurerm_keyvault_certificate_status",
 			Help: "Azure KeyVault certificate status",
 		},
-		[]string{
-			"resourceID",
-			"vaultName",
-			"certificateID",
-			"type",
-		},
+		m.contentTagManager.AddToPrometheusStatusLabels(
+			[]string{
+				"target",
+				"tenantID",
+				"resourceID",
+				"vaultName",
+				"certificateID",
+				"type",
+			},
+		),
 	)
-	m.Collector.RegisterMetricList("keyvaultCertificateStatus", m.prometheus.keyvaultCertificateStatus, true)
-
+	register("keyvaultCertificateStatus", m.prometheus.keyvaultCertificateStatus)
 }
 
-func (m *MetricsCollectorKeyvault) Reset() {}
-
 func (m *MetricsCollectorKeyvault) Collect(callback chan<- func()) {
-	var filterResourceIdMap *map[string]string
-	ctx := m.Context()
-
-	if len(Opts.KeyVault.Filter) > 0 {
-		// get list of subscriptions
-		subscriptionList, err := AzureSubscriptionsIterator.ListSubscriptions()
-		if err != nil {
-			panic(err)
-		}
+	m.runStart()
+	if m.standby() {
+		return
+	}
 
-		filterSubscriptions := []string{}
-		for _, subscription := range subscriptionList {
-			filterSubscriptions = append(filterSubscriptions, *subscription.SubscriptionID)
-		}
+	// overall collection deadline, KeyVaults which are not finished are reported as timeout
+	ctx, cancel := m.collectContext()
 
-		filters := []string{
-			`where type =~ "microsoft.keyvault/vaults"`,
-			Opts.KeyVault.Filter,
+	skip := m.scheduleSkip
+	if m.inventory != nil {
+		// metadata of all KeyVaults, also of KeyVaults which are not due
+		skip = func(target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool {
+			m.inventory.collectKeyVaultInfo(ctx, target, vault, logger)
+			return m.scheduleSkip(target, vault, logger)
 		}
+	}
 
-		// get list of resourceids based on kusto query
-		opts := armclient.ResourceGraphOptions{
-			Subscriptions: filterSubscriptions,
-		}
-		resourceIdMap, err := AzureClient.ListResourceIdsWithKustoFilter(ctx, filters, opts)
-		if err != nil {
-			logger.Fatal(err)
-		}
+	m.collectKeyVaults(ctx, false, skip, m.collectKeyVaultScheduled, m.keyvaultSeen)
 
-		filterResourceIdMap = &resourceIdMap
+	// executed after all KeyVaults are collected
+	callback <- func() {
+		cancel()
+		m.runFinish()
+		m.collectLastSuccess()
+		m.cleanupSnapshots()
+		m.schedule.cleanup()
 	}
+}
+
+func (m *MetricsCollectorKeyvault) collectKeyVault(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) (status bool) {
+	status = true
+
+	// vault tags for content tag inheritance
+	vaultTags := m.contentTagManager.FetchVaultTags(vault)
+
+	// ########################
+	// Clients
+	// ########################
 
-	err := AzureSubscriptionsIterator.ForEachAsync(m.Logger(), func(subscription *armsubscriptions.Subscription, logger *zap.SugaredLogger) {
-		m.collectSubscription(ctx, callback, subscription, logger, filterResourceIdMap)
-	})
+	keyOpts := azkeys.ClientOptions{
+		ClientOptions: target.NewAzCoreClientOptions(),
+	}
+	keyClient, err := azkeys.NewClient(vault.URL, target.GetCred(), &keyOpts)
 	if err != nil {
-		m.Logger().Panic(err)
+		m.collectError(CollectErrorScopeVault, err)
+		logger.Error(err)
+		return false
 	}
-}
 
-func (m *MetricsCollectorKeyvault) collectSubscription(ctx context.Context, callback chan<- func(), subscription *armsubscriptions.Subscription, logger *zap.SugaredLogger, filterResourceIdMap *map[string]string) {
-	var err error
-
-	keyvaultClient, err := armkeyvault.NewVaultsClient(*subscription.SubscriptionID, AzureClient.GetCred(), AzureClient.NewArmClientOptions())
+	secretOpts := azsecrets.ClientOptions{
+		ClientOptions: target.NewAzCoreClientOptions(),
+	}
+	secretClient, err := azsecrets.NewClient(vault.URL, target.GetCred(), &secretOpts)
 	if err != nil {
-		logger.Panic(err)
+		m.collectError(CollectErrorScopeVault, err)
+		logger.Error(err)
+		return false
 	}
 
-	pager := keyvaultClient.NewListBySubscriptionPager(nil)
+	certificateOpts := azcertificates.ClientOptions{
+		ClientOptions: target.NewAzCoreClientOptions(),
+	}
+	certificateClient, err := azcertificates.NewClient(vault.URL, target.GetCred(), &certificateOpts)
+	if err != nil {
+		m.collectError(CollectErrorScopeVault, err)
+		logger.Error(err)
+		return false
+	}
 
-	for pager.More() {
-		result, err := pager.NextPage(m.Context())
-		if err != nil {
-			logger.Panic(err)
-		}
+	// ########################
+	// Content (keys, secrets, certificates)
+	// ########################
 
-		if result.Value == nil {
-			continue
-		}
+	contentCollectors := []struct {
+		scope   string
+		collect func(ctx context.Context) (*keyvaultSnapshot, error)
+	}{
+		{CollectErrorScopeKeys, func(ctx context.Context) (*keyvaultSnapshot, error) {
+			return m.collectKeyVaultKeys(ctx, target, vault, keyClient, vaultTags, logger)
+		}},
+		{CollectErrorScopeSecrets, func(ctx context.Context) (*keyvaultSnapshot, error) {
+			return m.collectKeyVaultSecrets(ctx, target, vault, secretClient, vaultTags, logger)
+		}},
+		{CollectErrorScopeCertificates, func(ctx context.Context) (*keyvaultSnapshot, error) {
+			return m.collectKeyVaultCertificates(ctx, target, vault, certificateClient, vaultTags, logger)
+		}},
+	}
 
-		for _, row := range result.Value {
-			keyvault := row
-
-			if filterResourceIdMap != nil {
-				// filter is active, check if resourceid was found earlier using $filter list call
-				resourceId := to.StringLower(keyvault.ID)
-				if _, exists := (*filterResourceIdMap)[resourceId]; !exists {
-					logger.Debugf(`ignoring %v, not matching keyvault filter`, resourceId)
-					continue
-				}
-			}
+	// content is listed in parallel (limited by per KeyVault concurrency)
+	statusLock := sync.Mutex{}
+	wg := sizedwaitgroup.New(Opts.Scrape.VaultConcurrency)
+	for _, row := range contentCollectors {
+		wg.Add()
+		go func(scope string, collect func(ctx context.Context) (*keyvaultSnapshot, error)) {
+			defer wg.Done()
 
-			azureResource, _ := armclient.ParseResourceId(*keyvault.ID)
+			scopeCtx, span := tracer.Start(ctx, "collectKeyVault."+scope, trace.WithAttributes(attribute.String("scope", scope)))
 
-			contextLogger := logger.With(
-				zap.String("keyvault", azureResource.ResourceName),
-				zap.String("location", to.String(keyvault.Location)),
-				zap.String("resourceGroup", azureResource.ResourceGroup),
+			var (
+				snapshot *keyvaultSnapshot
+				err      error
 			)
 
-			m.WaitGroup().Add()
-			go func(keyvault *armkeyvault.Vault, contextLogger *zap.SugaredLogger) {
-				defer m.WaitGroup().Done()
-				contextLogger.Info("collecting keyvault metrics")
-				m.collectKeyVault(callback, subscription, keyvault, contextLogger)
-			}(keyvault, contextLogger)
-		}
-	}
-}
-
-func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subscription *armsubscriptions.Subscription, vault *armkeyvault.Vault, logger *zap.SugaredLogger) (status bool) {
-	status = true
+			startTime := time.Now()
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						err = m.collectPanic(scope, r)
+						logger.Error(err)
+					}
+				}()
+				snapshot, err = collect(scopeCtx)
+			}()
+			tracingEnd(span, err)
+			duration := time.Since(startTime)
+			m.observeVaultDuration(scope, duration)
+
+			if snapshot == nil {
+				snapshot = newKeyvaultSnapshot()
+			}
 
-	vaultMetrics := m.Collector.GetMetricList("keyvault")
-	vaultStatusMetrics := m.Collector.GetMetricList("keyvaultStatus")
-	vaultKeyMetrics := m.Collector.GetMetricList("keyvaultKeyInfo")
-	vaultKeyStatusMetrics := m.Collector.GetMetricList("keyvaultKeyStatus")
-	vaultSecretMetrics := m.Collector.GetMetricList("keyvaultSecretInfo")
-	vaultSecretStatusMetrics := m.Collector.GetMetricList("keyvaultSecretStatus")
-	vaultCertificateMetrics := m.Collector.GetMetricList("keyvaultCertificateInfo")
-	vaultCertificateStatusMetrics := m.Collector.GetMetricList("keyvaultCertificateStatus")
-	vaultEntryCountMetrics := m.Collector.GetMetricList("keyvaultEntryCount")
+			if err != nil {
+				statusLock.Lock()
+				status = false
+				statusLock.Unlock()
+			}
 
-	vaultUrl := to.String(vault.Properties.VaultURI)
+			m.publishKeyVaultSnapshot(target, vault, scope, snapshot, err, duration, logger)
+		}(row.scope, row.collect)
+	}
+	wg.Wait()
 
-	vaultResourceId := to.StringLower(vault.ID)
+	return
+}
 
-	azureResource, _ := armclient.ParseResourceId(vaultResourceId)
+// collectKeyVaultKeys lists all keys of KeyVault into a snapshot, on errors the partial snapshot is returned
+func (m *MetricsCollectorKeyvault) collectKeyVaultKeys(ctx context.Context, target *AzureTarget, vault *KeyVault, keyClient *azkeys.Client, vaultTags map[string]*string, logger *zap.SugaredLogger) (*keyvaultSnapshot, error) {
+	keySnapshot := newKeyvaultSnapshot()
+	vaultKeyMetrics := keySnapshot.metricList("keyvaultKeyInfo")
+	vaultKeyStatusMetrics := keySnapshot.metricList("keyvaultKeyStatus")
+	vaultKeyContentTagMetrics := keySnapshot.metricList("keyvaultContentTag")
 
-	entrySecretsCount := float64(0)
+	vaultResourceId := vault.ResourceID
 	entryKeysCount := float64(0)
-	entryCertsCount := float64(0)
-
-	// ########################
-	// Vault
-	// ########################
-
-	vaultLabels := prometheus.Labels{
-		"subscriptionID":   azureResource.Subscription,
-		"subscriptionName": to.String(subscription.DisplayName),
-		"resourceID":       vaultResourceId,
-		"vaultName":        azureResource.ResourceName,
-		"location":         to.String(vault.Location),
-		"resourceGroup":    azureResource.ResourceGroup,
-	}
-	vaultLabels = AzureResourceTagManager.AddResourceTagsToPrometheusLabels(m.Context(), vaultLabels, vaultResourceId)
-	vaultMetrics.AddInfo(vaultLabels)
-
-	// ########################
-	// Keys
-	// ########################
-
-	keyOpts := azkeys.ClientOptions{
-		ClientOptions: *AzureClient.NewAzCoreClientOptions(),
-	}
-	keyClient, err := azkeys.NewClient(vaultUrl, AzureClient.GetCred(), &keyOpts)
-	if err != nil {
-		logger.Panic(err.Error())
-	}
 
 	keyPager := keyClient.NewListKeyPropertiesPager(nil)
 
-	keyStatus := float64(1)
+	keyItems := []*azkeys.KeyProperties{}
+	var keyErr error
+	pages := 0
 	for keyPager.More() {
-		result, err := keyPager.NextPage(m.Context())
+		result, err := keyPager.NextPage(ctx)
 		if err != nil {
+			keyErr = err
+			m.collectError(CollectErrorScopeKeys, err)
 			logger.Warn(err)
-			keyStatus = 0
 			break
 		}
+		pages++
 
 		if result.Value == nil {
 			continue
@@ -416,20 +468,34 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 		for _, row := range result.Value {
 			item := row
 			entryKeysCount++
+			keyItems = append(keyItems, item)
 
 			itemID := string(*item.KID)
 			itemName := item.KID.Name()
+			contentTags := m.contentTagManager.ResolveContentTags(item.Tags, vaultTags)
+
+			for tagName, tagValue := range contentTags.Wildcard() {
+				vaultKeyContentTagMetrics.AddInfo(prometheus.Labels{
+					"resourceID": vaultResourceId,
+					"vaultName":  vault.Name,
+					"type":       "keys",
+					"itemID":     itemID,
+					"tag":        tagName,
+					"value":      tagValue,
+				})
+			}
 
 			vaultKeyMetrics.AddInfo(
-				m.contentTagManager.AddContentTags(
+				contentTags.AddToLabels(
 					prometheus.Labels{
+						"target":     target.Name,
+						"tenantID":   vault.TenantID,
 						"resourceID": vaultResourceId,
-						"vaultName":  azureResource.ResourceName,
+						"vaultName":  vault.Name,
 						"keyName":    itemName,
 						"keyID":      itemID,
 						"enabled":    to.BoolString(to.Bool(item.Attributes.Enabled)),
 					},
-					item.Tags,
 				),
 			)
 
@@ -438,79 +504,97 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 			if item.Attributes.Expires != nil {
 				expiryDate = float64(item.Attributes.Expires.Unix())
 			}
-			vaultKeyStatusMetrics.Add(prometheus.Labels{
+			vaultKeyStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"keyID":      itemID,
 				"type":       "expiry",
-			}, expiryDate)
+			}), expiryDate)
 
 			// not before
 			notBeforeDate := float64(0)
 			if item.Attributes.NotBefore != nil {
 				notBeforeDate = float64(item.Attributes.NotBefore.Unix())
 			}
-			vaultKeyStatusMetrics.Add(prometheus.Labels{
+			vaultKeyStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"keyID":      itemID,
 				"type":       "notBefore",
-			}, notBeforeDate)
+			}), notBeforeDate)
 
 			// created
 			createdDate := float64(0)
 			if item.Attributes.Created != nil {
 				createdDate = float64(item.Attributes.Created.Unix())
 			}
-			vaultKeyStatusMetrics.Add(prometheus.Labels{
+			vaultKeyStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"keyID":      itemID,
 				"type":       "created",
-			}, createdDate)
+			}), createdDate)
 
 			// updated
 			updatedDate := float64(0)
 			if item.Attributes.Updated != nil {
 				updatedDate = float64(item.Attributes.Updated.Unix())
 			}
-			vaultKeyStatusMetrics.Add(prometheus.Labels{
+			vaultKeyStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"keyID":      itemID,
 				"type":       "updated",
-			}, updatedDate)
+			}), updatedDate)
 		}
 	}
 
-	vaultStatusMetrics.Add(prometheus.Labels{
+	keySnapshot.metricList("keyvaultEntryCount").Add(prometheus.Labels{
 		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
-		"type":       "access",
-		"scope":      "keys",
-	}, keyStatus)
+		"vaultName":  vault.Name,
+		"type":       "keys",
+	}, entryKeysCount)
 
-	// ########################
-	// Secrets
-	// ########################
+	m.observeListing(CollectErrorScopeKeys, pages, int(entryKeysCount))
 
-	secretOpts := azsecrets.ClientOptions{
-		ClientOptions: *AzureClient.NewAzCoreClientOptions(),
-	}
-	secretClient, err := azsecrets.NewClient(vaultUrl, AzureClient.GetCred(), &secretOpts)
-	if err != nil {
-		logger.Panic(err.Error())
+	if keyErr == nil {
+		m.contentItems.SetKeys(target, vault, keyItems)
 	}
+
+	return keySnapshot, keyErr
+}
+
+// collectKeyVaultSecrets lists all secrets of KeyVault into a snapshot, on errors the partial snapshot is returned
+func (m *MetricsCollectorKeyvault) collectKeyVaultSecrets(ctx context.Context, target *AzureTarget, vault *KeyVault, secretClient *azsecrets.Client, vaultTags map[string]*string, logger *zap.SugaredLogger) (*keyvaultSnapshot, error) {
+	secretSnapshot := newKeyvaultSnapshot()
+	vaultSecretMetrics := secretSnapshot.metricList("keyvaultSecretInfo")
+	vaultSecretStatusMetrics := secretSnapshot.metricList("keyvaultSecretStatus")
+	vaultSecretContentTagMetrics := secretSnapshot.metricList("keyvaultContentTag")
+
+	vaultResourceId := vault.ResourceID
+	entrySecretsCount := float64(0)
+
 	secretPager := secretClient.NewListSecretPropertiesPager(nil)
 
-	secretStatus := float64(1)
+	var secretErr error
+	pages := 0
 	for secretPager.More() {
-		result, err := secretPager.NextPage(m.Context())
+		result, err := secretPager.NextPage(ctx)
 		if err != nil {
+			secretErr = err
+			m.collectError(CollectErrorScopeSecrets, err)
 			logger.Warn(err)
-			secretStatus = 0
 			break
 		}
+		pages++
 
 		if result.Value == nil {
 			continue
@@ -522,17 +606,30 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 
 			itemID := string(*item.ID)
 			itemName := item.ID.Name()
+			contentTags := m.contentTagManager.ResolveContentTags(item.Tags, vaultTags)
+
+			for tagName, tagValue := range contentTags.Wildcard() {
+				vaultSecretContentTagMetrics.AddInfo(prometheus.Labels{
+					"resourceID": vaultResourceId,
+					"vaultName":  vault.Name,
+					"type":       "secrets",
+					"itemID":     itemID,
+					"tag":        tagName,
+					"value":      tagValue,
+				})
+			}
 
 			vaultSecretMetrics.AddInfo(
-				m.contentTagManager.AddContentTags(
+				contentTags.AddToLabels(
 					prometheus.Labels{
+						"target":     target.Name,
+						"tenantID":   vault.TenantID,
 						"resourceID": vaultResourceId,
-						"vaultName":  azureResource.ResourceName,
+						"vaultName":  vault.Name,
 						"secretName": itemName,
 						"secretID":   itemID,
 						"enabled":    to.BoolString(to.Bool(item.Attributes.Enabled)),
 					},
-					item.Tags,
 				),
 			)
 
@@ -541,79 +638,94 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 			if item.Attributes.Expires != nil {
 				expiryDate = float64(item.Attributes.Expires.Unix())
 			}
-			vaultSecretStatusMetrics.Add(prometheus.Labels{
+			vaultSecretStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"secretID":   itemID,
 				"type":       "expiry",
-			}, expiryDate)
+			}), expiryDate)
 
 			// notbefore
 			notBeforeDate := float64(0)
 			if item.Attributes.NotBefore != nil {
 				notBeforeDate = float64(item.Attributes.NotBefore.Unix())
 			}
-			vaultSecretStatusMetrics.Add(prometheus.Labels{
+			vaultSecretStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"secretID":   itemID,
 				"type":       "notBefore",
-			}, notBeforeDate)
+			}), notBeforeDate)
 
 			// created
 			createdDate := float64(0)
 			if item.Attributes.Created != nil {
 				createdDate = float64(item.Attributes.Created.Unix())
 			}
-			vaultSecretStatusMetrics.Add(prometheus.Labels{
+			vaultSecretStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"secretID":   itemID,
 				"type":       "created",
-			}, createdDate)
+			}), createdDate)
 
 			// updated
 			updatedDate := float64(0)
 			if item.Attributes.Updated != nil {
 				updatedDate = float64(item.Attributes.Updated.Unix())
 			}
-			vaultSecretStatusMetrics.Add(prometheus.Labels{
+			vaultSecretStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":     target.Name,
+				"tenantID":   vault.TenantID,
 				"resourceID": vaultResourceId,
-				"vaultName":  azureResource.ResourceName,
+				"vaultName":  vault.Name,
 				"secretID":   itemID,
 				"type":       "updated",
-			}, updatedDate)
+			}), updatedDate)
 		}
 	}
 
-	vaultStatusMetrics.Add(prometheus.Labels{
+	secretSnapshot.metricList("keyvaultEntryCount").Add(prometheus.Labels{
 		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
-		"type":       "access",
-		"scope":      "secrets",
-	}, secretStatus)
+		"vaultName":  vault.Name,
+		"type":       "secrets",
+	}, entrySecretsCount)
 
-	// ########################
-	// Certificate
-	// ########################
+	m.observeListing(CollectErrorScopeSecrets, pages, int(entrySecretsCount))
+
+	return secretSnapshot, secretErr
+}
+
+// collectKeyVaultCertificates lists all certificates of KeyVault into a snapshot, on errors the partial snapshot is returned
+func (m *MetricsCollectorKeyvault) collectKeyVaultCertificates(ctx context.Context, target *AzureTarget, vault *KeyVault, certificateClient *azcertificates.Client, vaultTags map[string]*string, logger *zap.SugaredLogger) (*keyvaultSnapshot, error) {
+	certificateSnapshot := newKeyvaultSnapshot()
+	vaultCertificateMetrics := certificateSnapshot.metricList("keyvaultCertificateInfo")
+	vaultCertificateStatusMetrics := certificateSnapshot.metricList("keyvaultCertificateStatus")
+	vaultCertificateContentTagMetrics := certificateSnapshot.metricList("keyvaultContentTag")
+
+	vaultResourceId := vault.ResourceID
+	entryCertsCount := float64(0)
 
-	certificateOpts := azcertificates.ClientOptions{
-		ClientOptions: *AzureClient.NewAzCoreClientOptions(),
-	}
-	certificateClient, err := azcertificates.NewClient(vaultUrl, AzureClient.GetCred(), &certificateOpts)
-	if err != nil {
-		logger.Panic(err.Error())
-	}
 	certificatePager := certificateClient.NewListCertificatePropertiesPager(nil)
 
-	certificateStatus := float64(1)
+	certificateItems := []*azcertificates.CertificateProperties{}
+	var certificateErr error
+	pages := 0
 	for certificatePager.More() {
-		result, err := certificatePager.NextPage(m.Context())
+		result, err := certificatePager.NextPage(ctx)
 		if err != nil {
+			certificateErr = err
+			m.collectError(CollectErrorScopeCertificates, err)
 			logger.Warn(err)
-			certificateStatus = 0
 			break
 		}
+		pages++
 
 		if result.Value == nil {
 			continue
@@ -622,20 +734,34 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 		for _, row := range result.Value {
 			item := row
 			entryCertsCount++
+			certificateItems = append(certificateItems, item)
 
 			itemID := string(*item.ID)
 			itemName := item.ID.Name()
+			contentTags := m.contentTagManager.ResolveContentTags(item.Tags, vaultTags)
+
+			for tagName, tagValue := range contentTags.Wildcard() {
+				vaultCertificateContentTagMetrics.AddInfo(prometheus.Labels{
+					"resourceID": vaultResourceId,
+					"vaultName":  vault.Name,
+					"type":       "certificates",
+					"itemID":     itemID,
+					"tag":        tagName,
+					"value":      tagValue,
+				})
+			}
 
 			vaultCertificateMetrics.AddInfo(
-				m.contentTagManager.AddContentTags(
+				contentTags.AddToLabels(
 					prometheus.Labels{
+						"target":          target.Name,
+						"tenantID":        vault.TenantID,
 						"resourceID":      vaultResourceId,
-						"vaultName":       azureResource.ResourceName,
+						"vaultName":       vault.Name,
 						"certificateName": itemName,
 						"certificateID":   itemID,
 						"enabled":         to.BoolString(to.Bool(item.Attributes.Enabled)),
 					},
-					item.Tags,
 				),
 			)
 
@@ -644,76 +770,71 @@ func (m *MetricsCollectorKeyvault) collectKeyVault(callback chan<- func(), subsc
 			if item.Attributes.Expires != nil {
 				expiryDate = float64(item.Attributes.Expires.Unix())
 			}
-			vaultCertificateStatusMetrics.Add(prometheus.Labels{
+			vaultCertificateStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":        target.Name,
+				"tenantID":      vault.TenantID,
 				"resourceID":    vaultResourceId,
-				"vaultName":     azureResource.ResourceName,
+				"vaultName":     vault.Name,
 				"certificateID": itemID,
 				"type":          "expiry",
-			}, expiryDate)
+			}), expiryDate)
 
 			// notBefore
 			notBeforeDate := float64(0)
 			if item.Attributes.NotBefore != nil {
 				notBeforeDate = float64(item.Attributes.NotBefore.Unix())
 			}
-			vaultCertificateStatusMetrics.Add(prometheus.Labels{
+			vaultCertificateStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":        target.Name,
+				"tenantID":      vault.TenantID,
 				"resourceID":    vaultResourceId,
-				"vaultName":     azureResource.ResourceName,
+				"vaultName":     vault.Name,
 				"certificateID": itemID,
 				"type":          "notBefore",
-			}, notBeforeDate)
+			}), notBeforeDate)
 
 			// created
 			createdDate := float64(0)
 			if item.Attributes.Created != nil {
 				createdDate = float64(item.Attributes.Created.Unix())
 			}
-			vaultCertificateStatusMetrics.Add(prometheus.Labels{
+			vaultCertificateStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":        target.Name,
+				"tenantID":      vault.TenantID,
 				"resourceID":    vaultResourceId,
-				"vaultName":     azureResource.ResourceName,
+				"vaultName":     vault.Name,
 				"certificateID": itemID,
 				"type":          "created",
-			}, createdDate)
+			}), createdDate)
 
 			// updated
 			updatedDate := float64(0)
 			if item.Attributes.Updated != nil {
 				updatedDate = float64(item.Attributes.Updated.Unix())
 			}
-			vaultCertificateStatusMetrics.Add(prometheus.Labels{
+			vaultCertificateStatusMetrics.Add(contentTags.AddToStatusLabels(prometheus.Labels{
+				"target":        target.Name,
+				"tenantID":      vault.TenantID,
 				"resourceID":    vaultResourceId,
-				"vaultName":     azureResource.ResourceName,
+				"vaultName":     vault.Name,
 				"certificateID": itemID,
 				"type":          "updated",
-			}, updatedDate)
+			}), updatedDate)
 
 		}
 	}
 
-	vaultStatusMetrics.Add(prometheus.Labels{
-		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
-		"type":       "access",
-		"scope":      "certificates",
-	}, certificateStatus)
-
-	vaultEntryCountMetrics.Add(prometheus.Labels{
+	certificateSnapshot.metricList("keyvaultEntryCount").Add(prometheus.Labels{
 		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
-		"type":       "secrets",
-	}, entrySecretsCount)
-
-	vaultEntryCountMetrics.Add(prometheus.Labels{
-		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
-		"type":       "keys",
-	}, entryKeysCount)
-
-	vaultEntryCountMetrics.Add(prometheus.Labels{
-		"resourceID": vaultResourceId,
-		"vaultName":  azureResource.ResourceName,
+		"vaultName":  vault.Name,
 		"type":       "certificates",
 	}, entryCertsCount)
 
-	return
+	m.observeListing(CollectErrorScopeCertificates, pages, int(entryCertsCount))
+
+	if certificateErr == nil {
+		m.contentItems.SetCertificates(target, vault, certificateItems)
+	}
+
+	return certificateSnapshot, certificateErr
 }
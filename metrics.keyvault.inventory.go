@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/prometheus/collector"
+	"github.com/webdevops/go-common/utils/to"
+	"go.uber.org/zap"
+)
+
+// MetricsCollectorKeyvaultInventory discovers all KeyVaults (shared with the other collectors) and collects the KeyVault metadata
+type MetricsCollectorKeyvaultInventory struct {
+	MetricsCollectorKeyvaultBase
+
+	prometheus struct {
+		keyvault      *prometheus.GaugeVec
+		keyvaultShard *prometheus.GaugeVec
+	}
+}
+
+func (m *MetricsCollectorKeyvaultInventory) Setup(collector *collector.Collector) {
+	m.Processor.Setup(collector)
+
+	m.initMetrics(m.registerMetricList)
+}
+
+// initMetrics creates all metric vecs and registers them using register func
+func (m *MetricsCollectorKeyvaultInventory) initMetrics(register func(name string, vec *prometheus.GaugeVec)) {
+	m.prometheus.keyvault = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_info",
+			Help: "Azure KeyVault information",
+		},
+		// resource tag config is the same for all targets
+		AzureTargets[0].ResourceTagManager.AddToPrometheusLabels(
+			[]string{
+				"target",
+				"tenantID",
+				"subscriptionID",
+				"subscriptionName",
+				"resourceID",
+				"vaultName",
+				"location",
+				"resourceGroup",
+				"sku",
+				"publicNetworkAccess",
+				"networkDefaultAction",
+			},
+		),
+	)
+	register("keyvault", m.prometheus.keyvault)
+
+	m.prometheus.keyvaultShard = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_shard_assignment",
+			Help: "Azure KeyVault shard assignment (each KeyVault should be reported by exactly one replica)",
+		},
+		[]string{
+			"target",
+			"resourceID",
+			"vaultName",
+			"shard",
+		},
+	)
+	register("keyvaultShard", m.prometheus.keyvaultShard)
+}
+
+func (m *MetricsCollectorKeyvaultInventory) Collect(callback chan<- func()) {
+	m.runStart()
+	if m.standby() {
+		return
+	}
+
+	ctx, cancel := m.collectContext()
+
+	m.collectKeyVaults(ctx, true, nil, m.collectKeyVaultInfo, nil)
+
+	callback <- func() {
+		cancel()
+		m.runFinish()
+	}
+}
+
+// collectKeyVaultInfo collects KeyVault metadata (incl. resource tags)
+func (m *MetricsCollectorKeyvaultInventory) collectKeyVaultInfo(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool {
+	vaultLabels := prometheus.Labels{
+		"target":               target.Name,
+		"tenantID":             vault.TenantID,
+		"subscriptionID":       vault.SubscriptionID,
+		"subscriptionName":     vault.SubscriptionName,
+		"resourceID":           vault.ResourceID,
+		"vaultName":            vault.Name,
+		"location":             vault.Location,
+		"resourceGroup":        vault.ResourceGroup,
+		"sku":                  vault.Sku,
+		"publicNetworkAccess":  vault.PublicNetworkAccess,
+		"networkDefaultAction": vault.NetworkDefaultAction,
+	}
+	if vault.Static {
+		// static KeyVault, no ARM lookups, resource tags are taken from config
+		vaultLabels = target.ResourceTagManager.AddResourceTagsToPrometheusLabels(ctx, vaultLabels, "")
+		for name, value := range vault.Labels {
+			vaultLabels[name] = value
+		}
+	} else {
+		vaultLabels = keyvaultResourceTagLabels(ctx, target, vault, vaultLabels)
+	}
+	m.metricList("keyvault").AddInfo(vaultLabels)
+
+	if keyvaultSharding != nil {
+		m.metricList("keyvaultShard").AddInfo(prometheus.Labels{
+			"target":     target.Name,
+			"resourceID": vault.ResourceID,
+			"vaultName":  vault.Name,
+			"shard":      strconv.Itoa(keyvaultSharding.Shard(vault.ResourceID)),
+		})
+	}
+
+	return true
+}
+
+// keyvaultResourceTagLabels adds resource tag labels of KeyVault, resource tags are taken from the discovery result (ARM or
+// ResourceGraph) so no ARM lookup per KeyVault is needed. Only tags with resourcegroup/subscription source (or inherit for
+// empty tags) are looked up using the cached resourcegroup and subscription lists of the target.
+func keyvaultResourceTagLabels(ctx context.Context, target *AzureTarget, vault *KeyVault, labels prometheus.Labels) prometheus.Labels {
+	for _, tagConfig := range target.ResourceTagManager.Tags {
+		sources := []string{armclient.AzureTagSourceResource}
+		switch tagConfig.Source {
+		case armclient.AzureTagSourceResourceGroup:
+			sources = []string{armclient.AzureTagSourceResourceGroup}
+		case armclient.AzureTagSourceSubscription:
+			sources = []string{armclient.AzureTagSourceSubscription}
+		}
+
+		if tagConfig.Inherit {
+			// inherit from resourcegroup and subscription (only upwards)
+			switch sources[0] {
+			case armclient.AzureTagSourceResource:
+				sources = append(sources, armclient.AzureTagSourceResourceGroup, armclient.AzureTagSourceSubscription)
+			case armclient.AzureTagSourceResourceGroup:
+				sources = append(sources, armclient.AzureTagSourceSubscription)
+			}
+		}
+
+		value := ""
+		for _, source := range sources {
+			tags := vault.Tags
+			if source != armclient.AzureTagSourceResource {
+				var err error
+				if tags, err = target.GetResourceContainerTags(ctx, vault, source); err != nil {
+					logger.Warnf(`unable to fetch %s tags for resource "%s": %v`, source, vault.ResourceID, err.Error())
+					continue
+				}
+			}
+
+			if val, exists := tags[tagConfig.Name]; exists {
+				value = strings.TrimSpace(to.String(val))
+			}
+
+			if value != "" {
+				break
+			}
+		}
+
+		// same transformations as go-common tag manager
+		if tagConfig.Transform.ToLower {
+			value = strings.ToLower(value)
+		}
+		if tagConfig.Transform.ToUpper {
+			value = strings.ToUpper(value)
+		}
+		labels[tagConfig.TargetName] = value
+	}
+
+	return labels
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+var (
+	// content listing of KeyVaults, written by content collector and reused by details collector
+	keyvaultContentItems = newKeyvaultContentItemList()
+)
+
+type (
+	// keyvaultContentItemList contains the last complete key and certificate listing per KeyVault
+	keyvaultContentItemList struct {
+		list map[string]*keyvaultContentItemEntry
+		lock sync.Mutex
+	}
+
+	keyvaultContentItemEntry struct {
+		keys         []*azkeys.KeyProperties
+		certificates []*azcertificates.CertificateProperties
+		lastSeen     time.Time
+	}
+)
+
+func newKeyvaultContentItemList() *keyvaultContentItemList {
+	return &keyvaultContentItemList{
+		list: map[string]*keyvaultContentItemEntry{},
+	}
+}
+
+// SetKeys stores key listing of KeyVault (nil safe)
+func (l *keyvaultContentItemList) SetKeys(target *AzureTarget, vault *KeyVault, items []*azkeys.KeyProperties) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entry(target, vault).keys = items
+}
+
+// SetCertificates stores certificate listing of KeyVault (nil safe)
+func (l *keyvaultContentItemList) SetCertificates(target *AzureTarget, vault *KeyVault, items []*azcertificates.CertificateProperties) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entry(target, vault).certificates = items
+}
+
+// Get returns key and certificate listing of KeyVault, false if KeyVault was not listed yet
+func (l *keyvaultContentItemList) Get(target *AzureTarget, vault *KeyVault) ([]*azkeys.KeyProperties, []*azcertificates.CertificateProperties, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry, exists := l.list[target.Name+":"+vault.ResourceID]
+	if !exists {
+		return nil, nil, false
+	}
+
+	entry.lastSeen = time.Now()
+	return entry.keys, entry.certificates, true
+}
+
+// cleanup removes listings of KeyVaults which were not discovered anymore
+func (l *keyvaultContentItemList) cleanup() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for key, entry := range l.list {
+		if time.Since(entry.lastSeen) > keyvaultScheduleRetention {
+			delete(l.list, key)
+		}
+	}
+}
+
+// entry returns listing entry of KeyVault (created if not exists), lock has to be held by caller
+func (l *keyvaultContentItemList) entry(target *AzureTarget, vault *KeyVault) *keyvaultContentItemEntry {
+	key := target.Name + ":" + vault.ResourceID
+	if _, exists := l.list[key]; !exists {
+		l.list[key] = &keyvaultContentItemEntry{
+			lastSeen: time.Now(),
+		}
+	}
+	return l.list[key]
+}
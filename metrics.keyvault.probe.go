@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-keyvault-exporter/config"
+)
+
+const (
+	// time reserved for rendering the probe response
+	probeResponseMargin = 500 * time.Millisecond
+)
+
+var (
+	// allowed KeyVault dns suffixes for probe targets (token is only sent to KeyVault endpoints)
+	probeAllowedHostSuffixes = []string{
+		".vault.azure.net",
+		".vault.azure.cn",
+		".vault.usgovcloudapi.net",
+		".vault.microsoftazure.de",
+	}
+)
+
+// handleProbe collects one KeyVault synchronously into a per-request registry (blackbox exporter style)
+//
+//	/probe?target=https://myvault.vault.azure.net/[&azureTarget=name]
+func handleProbe(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	vaultUrl := params.Get("target")
+	if vaultUrl == "" {
+		http.Error(w, `parameter "target" is missing`, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateProbeTarget(vaultUrl); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, vault, err := findProbeTarget(vaultUrl, params.Get("azureTarget"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(w, r))
+	defer cancel()
+
+	// trace context of caller (traceparent header) is used as parent
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "probe", trace.WithAttributes(
+		attribute.String("target", target.Name),
+		attribute.String("keyvault", vault.Name),
+		attribute.String("resourceID", vault.ResourceID),
+	))
+	defer span.End()
+
+	contextLogger := tracingLogger(ctx, logger.With(
+		zap.String("probe", vault.URL),
+		zap.String("target", target.Name),
+		zap.String("keyvault", vault.Name),
+	))
+
+	registry := prometheus.NewRegistry()
+
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "azurerm_keyvault_probe_success",
+		Help: "Azure KeyVault probe success",
+	})
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "azurerm_keyvault_probe_duration_seconds",
+		Help: "Azure KeyVault probe duration",
+	})
+	registry.MustRegister(probeSuccess, probeDuration)
+
+	startTime := time.Now()
+	success, err := probeKeyVault(ctx, registry, target, vault, contextLogger)
+	if err != nil {
+		span.RecordError(err)
+		contextLogger.Error(err)
+	}
+	if !success {
+		span.SetStatus(codes.Error, "probe failed")
+	}
+
+	probeDuration.Set(time.Since(startTime).Seconds())
+	if success {
+		probeSuccess.Set(1)
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeKeyVault runs the KeyVault collection using local metric lists and writes the metrics to registry
+func probeKeyVault(ctx context.Context, registry *prometheus.Registry, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) (success bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			success = false
+			err = fmt.Errorf(`panic while probing KeyVault "%s": %v`, vault.URL, r)
+		}
+	}()
+
+	base := MetricsCollectorKeyvaultBase{
+		metricLists: map[string]*prometheusCommon.MetricList{},
+	}
+
+	vecList := map[string]*prometheus.GaugeVec{}
+	register := func(name string, vec *prometheus.GaugeVec) {
+		registry.MustRegister(vec)
+		vecList[name] = vec
+		base.metricLists[name] = prometheusCommon.NewMetricsList()
+	}
+
+	// inventory (metadata)
+	inventory := &MetricsCollectorKeyvaultInventory{MetricsCollectorKeyvaultBase: base}
+	inventory.initMetrics(register)
+	inventory.collectKeyVaultInfo(ctx, target, vault, logger)
+
+	// content (listing is reused by details)
+	contentItems := newKeyvaultContentItemList()
+	content := &MetricsCollectorKeyvault{MetricsCollectorKeyvaultBase: base, contentItems: contentItems}
+	if err := content.initContentTagManager(); err != nil {
+		return false, err
+	}
+	content.initMetrics(register)
+	success = content.collectKeyVault(ctx, target, vault, logger)
+
+	// details (only if details collector is enabled)
+	if Opts.Scrape.TimeDetails > 0 {
+		details := &MetricsCollectorKeyvaultDetails{MetricsCollectorKeyvaultBase: base, contentItems: contentItems}
+		details.initMetrics(register)
+		success = details.collectKeyVaultDetails(ctx, target, vault, logger) && success
+	}
+
+	for name, vec := range vecList {
+		base.metricLists[name].GaugeSet(vec)
+	}
+
+	return success, nil
+}
+
+// validateProbeTarget checks if target is a valid KeyVault url
+func validateProbeTarget(vaultUrl string) error {
+	parsedUrl, err := url.Parse(vaultUrl)
+	if err != nil {
+		return fmt.Errorf(`invalid target "%s": %w`, vaultUrl, err)
+	}
+
+	if parsedUrl.Scheme != "https" {
+		return fmt.Errorf(`invalid target "%s": only https is supported`, vaultUrl)
+	}
+
+	hostname := strings.ToLower(parsedUrl.Hostname())
+	for _, suffix := range probeAllowedHostSuffixes {
+		if strings.HasSuffix(hostname, suffix) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(`invalid target "%s": not a KeyVault url (%s)`, vaultUrl, strings.Join(probeAllowedHostSuffixes, ", "))
+}
+
+// findProbeTarget returns Azure target and KeyVault for probe, configured static KeyVaults are preferred
+func findProbeTarget(vaultUrl, targetName string) (*AzureTarget, *KeyVault, error) {
+	vaultHost := ""
+	if parsedUrl, err := url.Parse(vaultUrl); err == nil {
+		vaultHost = strings.ToLower(parsedUrl.Host)
+	}
+
+	var target *AzureTarget
+	for _, row := range AzureTargets {
+		if targetName != "" && row.Name != targetName {
+			continue
+		}
+
+		// use configured static KeyVault (name, labels)
+		for _, vault := range row.StaticVaults {
+			if parsedUrl, err := url.Parse(vault.URL); err == nil && strings.EqualFold(parsedUrl.Host, vaultHost) {
+				return row, vault, nil
+			}
+		}
+
+		if target == nil {
+			target = row
+		}
+	}
+
+	if target == nil {
+		return nil, nil, fmt.Errorf(`Azure target "%s" not found`, targetName)
+	}
+
+	vault, err := NewKeyVaultFromConfig(config.ConfigVault{URL: vaultUrl}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return target, vault, nil
+}
+
+// probeTimeout returns probe timeout based on prometheus scrape timeout header, the write deadline of the response is
+// extended to the probe timeout (or the probe timeout is limited by the server write timeout if not possible)
+func probeTimeout(w http.ResponseWriter, r *http.Request) time.Duration {
+	timeout := Opts.Probe.Timeout
+
+	if val := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); val != "" {
+		if seconds, err := strconv.ParseFloat(val, 64); err == nil && seconds > 0 {
+			scrapeTimeout := time.Duration(seconds * float64(time.Second))
+			// keep some time for rendering the response
+			if scrapeTimeout > 2*probeResponseMargin {
+				scrapeTimeout -= probeResponseMargin
+			}
+
+			if timeout <= 0 || scrapeTimeout < timeout {
+				timeout = scrapeTimeout
+			}
+		}
+	}
+
+	if Opts.Server.WriteTimeout > 0 {
+		maxTimeout := max(Opts.Server.WriteTimeout-probeResponseMargin, Opts.Server.WriteTimeout/2)
+		if timeout <= 0 {
+			timeout = maxTimeout
+		}
+
+		// probe result (incl. probe_success 0) has to be written before the write deadline
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + probeResponseMargin))
+		if err != nil && timeout > maxTimeout {
+			timeout = maxTimeout
+		}
+	}
+
+	return timeout
+}
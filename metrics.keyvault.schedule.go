@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// schedule of KeyVaults which were not discovered anymore is removed after this duration
+	keyvaultScheduleRetention = 24 * time.Hour
+)
+
+type (
+	// keyvaultSchedule decides when KeyVaults are collected based on the nearest upcoming expiry of their content
+	keyvaultSchedule struct {
+		tiers           []keyvaultScheduleTier
+		defaultInterval time.Duration
+		jitter          float64
+
+		list map[string]*keyvaultScheduleEntry
+		lock sync.Mutex
+	}
+
+	// keyvaultScheduleTier defines the scrape interval of KeyVaults with content expiring within expiry
+	keyvaultScheduleTier struct {
+		expiry   time.Duration
+		interval time.Duration
+	}
+
+	keyvaultScheduleEntry struct {
+		labels   prometheus.Labels
+		interval time.Duration
+		lastRun  time.Time
+		nextRun  time.Time
+		lastSeen time.Time
+
+		// last published data per scope, replayed while KeyVault is not due
+		published map[string]*keyvaultSnapshot
+	}
+)
+
+// initSchedule creates the KeyVault schedule from args and the scrape interval metric (not available for probe requests)
+func (m *MetricsCollectorKeyvault) initSchedule() {
+	tiers, err := parseScheduleTiers(Opts.Scrape.Schedule.Tiers)
+	if err != nil {
+		m.Logger().Fatalf(`unable to parse schedule tiers: %v`, err.Error())
+	}
+
+	m.schedule = &keyvaultSchedule{
+		tiers:           tiers,
+		defaultInterval: Opts.Scrape.Schedule.Default,
+		jitter:          Opts.Scrape.Schedule.Jitter,
+		list:            map[string]*keyvaultScheduleEntry{},
+	}
+
+	if m.schedule.defaultInterval <= 0 {
+		m.schedule.defaultInterval = Opts.Scrape.Time
+	}
+
+	m.prometheus.keyvaultCollectInterval = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_collect_interval_seconds",
+			Help: "Azure KeyVault scrape interval of content (chosen by nearest upcoming expiry)",
+		},
+		[]string{
+			"target",
+			"resourceID",
+			"vaultName",
+		},
+	)
+	m.registerMetricList("keyvaultCollectInterval", m.prometheus.keyvaultCollectInterval)
+}
+
+// scheduleSkip returns true if KeyVault is not due, the last published data of the KeyVault is reported instead
+func (m *MetricsCollectorKeyvault) scheduleSkip(target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool {
+	if m.schedule == nil {
+		return false
+	}
+
+	m.schedule.lock.Lock()
+	defer m.schedule.lock.Unlock()
+
+	now := time.Now()
+	entry := m.schedule.entry(target, vault)
+	entry.lastSeen = now
+
+	if now.Before(entry.nextRun) {
+		for scope, snapshot := range entry.published {
+			m.writeKeyVaultSnapshot(vault, scope, snapshot)
+		}
+		m.metricList("keyvaultCollectInterval").Add(entry.labels, entry.interval.Seconds())
+		m.keyvaultSeen(target, vault, false)
+
+		logger.With(zap.String("keyvault", vault.Name)).Debugf(`keyvault not due, next collection at %s`, entry.nextRun.UTC().Format(time.RFC3339))
+		return true
+	}
+
+	entry.lastRun = now
+	return false
+}
+
+// collectKeyVaultScheduled collects KeyVault and schedules the next collection
+func (m *MetricsCollectorKeyvault) collectKeyVaultScheduled(ctx context.Context, target *AzureTarget, vault *KeyVault, logger *zap.SugaredLogger) bool {
+	status := m.collectKeyVault(ctx, target, vault, logger)
+	exporterHealth.vault(target, vault, status)
+
+	if m.schedule != nil {
+		m.schedule.lock.Lock()
+		defer m.schedule.lock.Unlock()
+
+		entry := m.schedule.entry(target, vault)
+		entry.interval = m.schedule.interval(entry.nearestExpiry())
+		if status {
+			entry.nextRun = entry.lastRun.Add(m.schedule.jitterInterval(entry.interval))
+		} else {
+			// failed KeyVaults are collected again in the next run
+			entry.nextRun = entry.lastRun
+		}
+
+		m.metricList("keyvaultCollectInterval").Add(entry.labels, entry.interval.Seconds())
+	}
+
+	return status
+}
+
+// published stores the last published data of KeyVault scope (nil safe, eg. for probe requests)
+func (s *keyvaultSchedule) published(target *AzureTarget, vault *KeyVault, scope string, snapshot *keyvaultSnapshot) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entry(target, vault).published[scope] = snapshot
+}
+
+// cleanup removes schedules of KeyVaults which were not discovered anymore
+func (s *keyvaultSchedule) cleanup() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, entry := range s.list {
+		if time.Since(entry.lastSeen) > keyvaultScheduleRetention {
+			delete(s.list, key)
+		}
+	}
+}
+
+// entry returns schedule entry of KeyVault (created if not exists), lock has to be held by caller
+func (s *keyvaultSchedule) entry(target *AzureTarget, vault *KeyVault) *keyvaultScheduleEntry {
+	key := target.Name + ":" + vault.ResourceID
+	if _, exists := s.list[key]; !exists {
+		s.list[key] = &keyvaultScheduleEntry{
+			labels: prometheus.Labels{
+				"target":     target.Name,
+				"resourceID": vault.ResourceID,
+				"vaultName":  vault.Name,
+			},
+			lastSeen:  time.Now(),
+			published: map[string]*keyvaultSnapshot{},
+		}
+	}
+	return s.list[key]
+}
+
+// interval returns scrape interval for KeyVault by nearest upcoming expiry, the collector runs every --scrape.time
+// (resolution of the schedule) so intervals below are collected in every run
+func (s *keyvaultSchedule) interval(nearestExpiry time.Time) time.Duration {
+	interval := s.defaultInterval
+
+	if !nearestExpiry.IsZero() {
+		remaining := time.Until(nearestExpiry)
+		for _, tier := range s.tiers {
+			if remaining <= tier.expiry {
+				interval = tier.interval
+				break
+			}
+		}
+	}
+
+	return interval
+}
+
+// jitterInterval adds random jitter to interval so KeyVaults are not collected all at once
+func (s *keyvaultSchedule) jitterInterval(interval time.Duration) time.Duration {
+	if s.jitter <= 0 || interval <= Opts.Scrape.Time {
+		// collected in every run anyway
+		return interval
+	}
+
+	return interval + time.Duration(float64(interval)*s.jitter*(rand.Float64()*2-1)) // #nosec G404 no crypto
+}
+
+// nearestExpiry returns nearest upcoming expiry of KeyVault content (zero if no content expires)
+func (e *keyvaultScheduleEntry) nearestExpiry() time.Time {
+	now := float64(time.Now().Unix())
+	nearest := float64(0)
+
+	for _, snapshot := range e.published {
+		for _, list := range snapshot.metrics {
+			for _, row := range list.GetList() {
+				if row.Labels["type"] != "expiry" || row.Value <= now {
+					continue
+				}
+
+				if nearest == 0 || row.Value < nearest {
+					nearest = row.Value
+				}
+			}
+		}
+	}
+
+	if nearest == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(nearest), 0)
+}
+
+// parseScheduleTiers parses tiers (expiry:interval, eg. 2d:5m) sorted by expiry
+func parseScheduleTiers(values []string) ([]keyvaultScheduleTier, error) {
+	tiers := []keyvaultScheduleTier{}
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(`invalid tier "%s", expected expiry:interval (eg. 2d:5m)`, value)
+		}
+
+		expiry, err := parseScheduleDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid tier "%s": %w`, value, err)
+		}
+
+		interval, err := parseScheduleDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid tier "%s": %w`, value, err)
+		}
+
+		tiers = append(tiers, keyvaultScheduleTier{expiry: expiry, interval: interval})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].expiry < tiers[j].expiry
+	})
+
+	return tiers, nil
+}
+
+// parseScheduleDuration parses duration with additional support for days (eg. 14d)
+func parseScheduleDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if days, found := strings.CutSuffix(value, "d"); found {
+		val, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf(`invalid duration "%s"`, value)
+		}
+		return time.Duration(val * float64(24*time.Hour)), nil
+	}
+
+	return time.ParseDuration(value)
+}
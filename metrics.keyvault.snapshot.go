@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"go.uber.org/zap"
+)
+
+type (
+	// keyvaultSnapshot contains the content metrics of one KeyVault scope (keys, secrets, certificates)
+	keyvaultSnapshot struct {
+		time    time.Time
+		metrics map[string]*prometheusCommon.MetricList
+
+		// last known good data was used because collection failed
+		stale bool
+	}
+
+	keyvaultSnapshotList struct {
+		list map[string]*keyvaultSnapshot
+		lock sync.Mutex
+	}
+)
+
+func newKeyvaultSnapshot() *keyvaultSnapshot {
+	return &keyvaultSnapshot{
+		time:    time.Now(),
+		metrics: map[string]*prometheusCommon.MetricList{},
+	}
+}
+
+// metricList returns metric list of snapshot by name (created if not exists)
+func (s *keyvaultSnapshot) metricList(name string) *prometheusCommon.MetricList {
+	if _, exists := s.metrics[name]; !exists {
+		s.metrics[name] = prometheusCommon.NewMetricsList()
+	}
+	return s.metrics[name]
+}
+
+// publishKeyVaultSnapshot writes snapshot, access status and collection duration to metric lists, if collection failed the last known good snapshot is used instead (if not expired)
+func (m *MetricsCollectorKeyvault) publishKeyVaultSnapshot(target *AzureTarget, vault *KeyVault, scope string, snapshot *keyvaultSnapshot, err error, duration time.Duration, logger *zap.SugaredLogger) {
+	data := snapshot
+
+	if m.snapshots != nil && Opts.KeyVault.Stale.MaxAge > 0 {
+		key := target.Name + ":" + vault.ResourceID + ":" + scope
+
+		m.snapshots.lock.Lock()
+		if err == nil {
+			m.snapshots.list[key] = snapshot
+		} else if lastKnownGood, exists := m.snapshots.list[key]; exists {
+			if time.Since(lastKnownGood.time) <= Opts.KeyVault.Stale.MaxAge {
+				logger.Warnf(`using last known good data for %s from %s`, scope, lastKnownGood.time.UTC().Format(time.RFC3339))
+				data = lastKnownGood
+			} else {
+				delete(m.snapshots.list, key)
+			}
+		}
+		m.snapshots.lock.Unlock()
+	}
+
+	// published data incl. access status (replayed if KeyVault is not collected in the next runs, see schedule)
+	published := &keyvaultSnapshot{
+		time:    data.time,
+		metrics: map[string]*prometheusCommon.MetricList{},
+		stale:   data != snapshot,
+	}
+	for name, list := range data.metrics {
+		published.metrics[name] = list
+	}
+
+	scopeStatus := float64(1)
+	if err != nil {
+		scopeStatus = 0
+	}
+	published.metricList("keyvaultStatus").Add(keyvaultAccessStatusLabels(vault, scope, err), scopeStatus)
+	published.metricList("keyvaultCollectDuration").Add(prometheus.Labels{
+		"resourceID": vault.ResourceID,
+		"vaultName":  vault.Name,
+		"scope":      scope,
+	}, duration.Seconds())
+
+	m.writeKeyVaultSnapshot(vault, scope, published)
+	m.schedule.published(target, vault, scope, published)
+}
+
+// writeKeyVaultSnapshot writes snapshot to metric lists, the data age is only reported if last known good data is used
+// (not for KeyVaults which are not due, see schedule)
+func (m *MetricsCollectorKeyvault) writeKeyVaultSnapshot(vault *KeyVault, scope string, snapshot *keyvaultSnapshot) {
+	for name, list := range snapshot.metrics {
+		metricList := m.metricList(name)
+		for _, row := range list.GetList() {
+			metricList.Add(row.Labels, row.Value)
+		}
+	}
+
+	dataAge := float64(0)
+	if snapshot.stale {
+		dataAge = time.Since(snapshot.time).Seconds()
+	}
+
+	m.metricList("keyvaultDataAge").Add(prometheus.Labels{
+		"resourceID": vault.ResourceID,
+		"vaultName":  vault.Name,
+		"scope":      scope,
+	}, dataAge)
+}
+
+// cleanupSnapshots removes expired snapshots (eg. from deleted KeyVaults)
+func (m *MetricsCollectorKeyvault) cleanupSnapshots() {
+	if m.snapshots == nil {
+		return
+	}
+
+	m.snapshots.lock.Lock()
+	defer m.snapshots.lock.Unlock()
+
+	for key, snapshot := range m.snapshots.list {
+		if time.Since(snapshot.time) > Opts.KeyVault.Stale.MaxAge {
+			delete(m.snapshots.list, key)
+		}
+	}
+}
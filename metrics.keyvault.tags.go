@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	ContentTagSourceItem  = "item"
+	ContentTagSourceVault = "vault"
+
+	ContentTagLabelPrefix     = "tag_"
+	ContentTagOptionCharacter = "?"
+	ContentTagOptionRegex     = "regex="
+	ContentTagWildcard        = "*"
+
+	ContentTagDefaultWildcardLimit = 10
+)
+
+var (
+	azureTagNameToPrometheusNameRegExp = regexp.MustCompile("[^_a-zA-Z0-9]")
+)
+
+type (
+	ContentTagManager struct {
+		config   []ContentTagConfig
+		wildcard []ContentTagConfig
+
+		// sources for tag values, ordered by precedence
+		sources []string
+
+		// also add content tags to status metrics
+		statusLabels bool
+	}
+
+	ContentTagConfig struct {
+		Label      string
+		Tag        string
+		Default    string
+		IgnoreCase bool
+		Limit      int
+		Transform  ContentTagConfigTransform
+	}
+
+	ContentTagConfigTransform struct {
+		ToLower bool
+		ToUpper bool
+		RegExp  *regexp.Regexp
+	}
+
+	// ContentTagValues contains resolved content tag values of one item
+	ContentTagValues struct {
+		labels       prometheus.Labels
+		wildcard     map[string]string
+		statusLabels bool
+	}
+)
+
+// NewContentTagManager creates new content tag manager
+func NewContentTagManager(tags []string, sources []string, defaultValue string, statusLabels bool) (*ContentTagManager, error) {
+	ctm := &ContentTagManager{
+		config:       []ContentTagConfig{},
+		wildcard:     []ContentTagConfig{},
+		statusLabels: statusLabels,
+	}
+
+	for _, source := range sources {
+		source = strings.ToLower(strings.TrimSpace(source))
+		switch source {
+		case ContentTagSourceItem, ContentTagSourceVault:
+			ctm.sources = append(ctm.sources, source)
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf(`invalid content tag source "%s"`, source)
+		}
+	}
+
+	for _, tag := range tags {
+		if err := ctm.AddTag(tag, defaultValue); err != nil {
+			return nil, fmt.Errorf(`unable to parse content tag config "%s": %w`, tag, err)
+		}
+	}
+
+	return ctm, nil
+}
+
+// AddTag parses tag config and adds tag to configuration
+//
+//	format is tagname?option1&option2=value (like resource tag manager), wildcard tags (eg. "*" or "team-*")
+//	are not added as labels but exported via content tag metric
+func (ctm *ContentTagManager) AddTag(tag, defaultValue string) error {
+	config := ContentTagConfig{
+		Tag:     tag,
+		Default: defaultValue,
+	}
+	targetName := tag
+
+	options := url.Values{}
+	if parts := strings.SplitN(tag, ContentTagOptionCharacter, 2); len(parts) == 2 {
+		var err error
+		config.Tag = parts[0]
+		targetName = parts[0]
+		options, err = parseContentTagOptions(parts[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Tag == "" {
+		return fmt.Errorf(`tag name is empty`)
+	}
+
+	if options.Has("name") {
+		targetName = options.Get("name")
+	}
+
+	if options.Has("default") {
+		config.Default = options.Get("default")
+	}
+
+	if options.Has("ignoreCase") || options.Has("ignorecase") {
+		config.IgnoreCase = true
+	}
+
+	if options.Has("toLower") || options.Has("tolower") {
+		config.Transform.ToLower = true
+	}
+
+	if options.Has("toUpper") || options.Has("toupper") {
+		config.Transform.ToUpper = true
+	}
+
+	if options.Has("regex") {
+		regExp, err := regexp.Compile(options.Get("regex"))
+		if err != nil {
+			return err
+		}
+		config.Transform.RegExp = regExp
+	}
+
+	if strings.Contains(config.Tag, ContentTagWildcard) {
+		if options.Has("name") {
+			return fmt.Errorf(`option "name" is not supported for wildcard tags`)
+		}
+
+		if _, err := path.Match(config.Tag, ""); err != nil {
+			return err
+		}
+
+		config.Limit = ContentTagDefaultWildcardLimit
+		if options.Has("limit") {
+			limit, err := strconv.Atoi(options.Get("limit"))
+			if err != nil {
+				return fmt.Errorf(`invalid limit "%s": %w`, options.Get("limit"), err)
+			}
+			config.Limit = limit
+		}
+
+		ctm.wildcard = append(ctm.wildcard, config)
+		return nil
+	}
+
+	config.Label = ContentTagLabelPrefix + azureTagNameToPrometheusNameRegExp.ReplaceAllLiteralString(strings.ToLower(targetName), "_")
+
+	ctm.config = append(ctm.config, config)
+	return nil
+}
+
+// parseContentTagOptions parses tag options (query string), the regex option is taken literally up to the end
+// (has to be the last option) so regular expressions don't need to be url encoded (eg. "+" or "&")
+func parseContentTagOptions(value string) (url.Values, error) {
+	regex := ""
+	hasRegex := false
+	if strings.HasPrefix(value, ContentTagOptionRegex) {
+		regex, hasRegex = strings.TrimPrefix(value, ContentTagOptionRegex), true
+		value = ""
+	} else if idx := strings.Index(value, "&"+ContentTagOptionRegex); idx >= 0 {
+		regex, hasRegex = value[idx+len(ContentTagOptionRegex)+1:], true
+		value = value[:idx]
+	}
+
+	options, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasRegex {
+		options.Set("regex", regex)
+	}
+
+	return options, nil
+}
+
+// IsSourceEnabled returns true if tag source is enabled
+func (ctm *ContentTagManager) IsSourceEnabled(source string) bool {
+	for _, row := range ctm.sources {
+		if row == source {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchVaultTags returns vault tags (used for inheritance)
+func (ctm *ContentTagManager) FetchVaultTags(vault *KeyVault) map[string]*string {
+	if !ctm.IsSourceEnabled(ContentTagSourceVault) || vault == nil {
+		return nil
+	}
+
+	return vault.Tags
+}
+
+// ResolveContentTags resolves content tag values for an item (item tag, vault tag, default value)
+func (ctm *ContentTagManager) ResolveContentTags(itemTags map[string]*string, vaultTags map[string]*string) ContentTagValues {
+	ret := ContentTagValues{
+		labels:       prometheus.Labels{},
+		statusLabels: ctm.statusLabels,
+	}
+
+	tagSources := ctm.tagSources(itemTags, vaultTags)
+
+	for _, row := range ctm.config {
+		// default value
+		ret.labels[row.Label] = row.Default
+
+		for _, tags := range tagSources {
+			if val, exists := row.lookup(tags); exists {
+				if val = row.transform(val); val != "" {
+					ret.labels[row.Label] = val
+				}
+				break
+			}
+		}
+	}
+
+	if len(ctm.wildcard) > 0 {
+		ret.wildcard = map[string]string{}
+
+		for _, row := range ctm.wildcard {
+			count := 0
+			for _, tags := range tagSources {
+				for _, tagName := range row.match(tags) {
+					if _, exists := ret.wildcard[tagName]; exists {
+						continue
+					}
+
+					if row.Limit >= 0 && count >= row.Limit {
+						break
+					}
+
+					ret.wildcard[tagName] = row.transform(to.String(tags[tagName]))
+					count++
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// tagSources returns list of tag maps ordered by precedence
+func (ctm *ContentTagManager) tagSources(itemTags map[string]*string, vaultTags map[string]*string) []map[string]*string {
+	ret := []map[string]*string{}
+	for _, source := range ctm.sources {
+		switch source {
+		case ContentTagSourceItem:
+			ret = append(ret, itemTags)
+		case ContentTagSourceVault:
+			ret = append(ret, vaultTags)
+		}
+	}
+	return ret
+}
+
+// AddToPrometheusLabels adds prometheus labels for metric definition
+func (ctm *ContentTagManager) AddToPrometheusLabels(val []string) []string {
+	for _, row := range ctm.config {
+		val = append(val, row.Label)
+	}
+
+	return val
+}
+
+// AddToPrometheusStatusLabels adds prometheus labels for status metric definition (if enabled)
+func (ctm *ContentTagManager) AddToPrometheusStatusLabels(val []string) []string {
+	if ctm.statusLabels {
+		val = ctm.AddToPrometheusLabels(val)
+	}
+
+	return val
+}
+
+// lookup returns tag value (non-empty) from tag map
+func (c *ContentTagConfig) lookup(tags map[string]*string) (string, bool) {
+	if val, exists := tags[c.Tag]; exists && to.String(val) != "" {
+		return to.String(val), true
+	}
+
+	if c.IgnoreCase {
+		for tagName, val := range tags {
+			if strings.EqualFold(tagName, c.Tag) && to.String(val) != "" {
+				return to.String(val), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// match returns sorted list of tag names matching the wildcard pattern
+func (c *ContentTagConfig) match(tags map[string]*string) []string {
+	ret := []string{}
+
+	pattern := c.Tag
+	if c.IgnoreCase {
+		pattern = strings.ToLower(pattern)
+	}
+
+	for tagName, val := range tags {
+		if to.String(val) == "" {
+			continue
+		}
+
+		name := tagName
+		if c.IgnoreCase {
+			name = strings.ToLower(name)
+		}
+
+		if matched, _ := path.Match(pattern, name); matched {
+			ret = append(ret, tagName)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+// transform applies configured transformations to tag value
+func (c *ContentTagConfig) transform(val string) string {
+	if c.Transform.RegExp != nil {
+		if match := c.Transform.RegExp.FindStringSubmatch(val); match != nil {
+			if len(match) >= 2 {
+				// use first capture group
+				val = match[1]
+			} else {
+				val = match[0]
+			}
+		} else {
+			val = ""
+		}
+	}
+
+	if c.Transform.ToLower {
+		val = strings.ToLower(val)
+	}
+
+	if c.Transform.ToUpper {
+		val = strings.ToUpper(val)
+	}
+
+	return val
+}
+
+// AddToLabels adds content tags to prometheus labels for metric
+func (v ContentTagValues) AddToLabels(labels prometheus.Labels) prometheus.Labels {
+	for name, value := range v.labels {
+		labels[name] = value
+	}
+
+	return labels
+}
+
+// AddToStatusLabels adds content tags to prometheus labels for status metric (if enabled)
+func (v ContentTagValues) AddToStatusLabels(labels prometheus.Labels) prometheus.Labels {
+	if v.statusLabels {
+		labels = v.AddToLabels(labels)
+	}
+
+	return labels
+}
+
+// Wildcard returns tags matched by wildcard tag config (tag name -> value)
+func (v ContentTagValues) Wildcard() map[string]string {
+	return v.wildcard
+}
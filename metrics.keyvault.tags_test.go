@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/webdevops/go-common/utils/to"
+)
+
+func TestContentTagManagerRegex(t *testing.T) {
+	tests := []struct {
+		tag     string
+		tagName string
+		value   string
+		label   string
+		want    string
+	}{
+		// "+" is not decoded as space
+		{tag: `owner?regex=^([a-z]+)@`, tagName: "owner", value: "alice@example.com", label: "tag_owner", want: "alice"},
+		{tag: `version?regex=v([0-9]+\.[0-9]+)`, tagName: "version", value: "v1.20+build", label: "tag_version", want: "1.20"},
+		// "&" is part of the regex, other options have to be set before regex
+		{tag: `team?name=group&regex=^(dev|ops)&`, tagName: "team", value: "ops&more", label: "tag_group", want: "ops"},
+		// regex without capture group returns whole match
+		{tag: `cost?default=none&regex=[0-9]+`, tagName: "cost", value: "cc-1234", label: "tag_cost", want: "1234"},
+	}
+
+	for _, test := range tests {
+		ctm, err := NewContentTagManager([]string{test.tag}, []string{ContentTagSourceItem}, "", false)
+		if err != nil {
+			t.Fatalf(`unable to parse content tag "%s": %v`, test.tag, err)
+		}
+
+		values := ctm.ResolveContentTags(map[string]*string{test.tagName: to.StringPtr(test.value)}, nil)
+		if got := values.AddToLabels(map[string]string{})[test.label]; got != test.want {
+			t.Errorf(`expected "%s" for content tag "%s" with value "%s", got "%s"`, test.want, test.tag, test.value, got)
+		}
+	}
+}
+
+func TestParseContentTagOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		regex   string
+		name    string
+	}{
+		{options: `regex=a+b`, regex: `a+b`},
+		{options: `name=foo&regex=a+b&c`, regex: `a+b&c`, name: "foo"},
+		{options: `name=foo+bar`, name: "foo bar"},
+		{options: `name=foo&regex=`, regex: ``, name: "foo"},
+	}
+
+	for _, test := range tests {
+		options, err := parseContentTagOptions(test.options)
+		if err != nil {
+			t.Fatalf(`unable to parse content tag options "%s": %v`, test.options, err)
+		}
+
+		if got := options.Get("regex"); got != test.regex {
+			t.Errorf(`expected regex "%s" for options "%s", got "%s"`, test.regex, test.options, got)
+		}
+
+		if got := options.Get("name"); got != test.name {
+			t.Errorf(`expected name "%s" for options "%s", got "%s"`, test.name, test.options, got)
+		}
+	}
+}